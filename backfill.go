@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdickers47/ourabridge/oura"
+)
+
+type backfillRequest struct {
+	user  string
+	start time.Time
+	end   time.Time
+	types []string
+}
+
+func splitTypes(s string) []string {
+	types := make([]string, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// backfillMain runs one backfill and exits.  Be careful running this
+// while the server is also running, because they will both want to
+// refresh the same oauth tokens, and Oura only lets you do that once.
+// The /admin/backfill handler is the safe way to do it then.
+func backfillMain(args []string) {
+	cc := oura.LoadClientConfig(*ClientFile)
+	Cfg = &cc
+
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	user := fs.String("user", "", "Username to backfill (required)")
+	start := fs.String("start", "",
+		"Start of the range, 2006-01-02 or RFC3339 (required)")
+	end := fs.String("end", time.Now().Format("2006-01-02"),
+		"End of the range, 2006-01-02 or RFC3339")
+	types := fs.String("types", "",
+		"Comma-separated document types, e.g. sleep,heartrate (default all)")
+	fs.Usage = func() {
+		log.Printf("usage: %s [flags] backfill [backfill flags]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(*user) == 0 || len(*start) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// the dates are the user's dates, the same as a search uses
+	loc := Cfg.UserLocation(*user)
+	t0 := parseDateFlag("start", *start, loc)
+	t1 := parseDateFlag("end", *end, loc)
+
+	observationChan := make(chan oura.Observation, 100)
+	done := make(chan bool)
+	go func() {
+		oura.StoreObservations(Cfg, observationChan)
+		done <- true
+	}()
+	_, err := oura.Backfill(Cfg, *user, t0, t1, splitTypes(*types),
+		observationChan)
+	close(observationChan)
+	<-done
+	if err != nil {
+		log.Fatalf("backfill incomplete: %s", err)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/mdickers47/ourabridge
 
 go 1.19
 
-require golang.org/x/oauth2 v0.21.0
+require (
+	github.com/golang/snappy v1.0.0
+	golang.org/x/oauth2 v0.21.0
+)
@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -129,8 +130,7 @@ func handleAuthCode(w http.ResponseWriter, r *http.Request,
 
 	// populate personal_info, which we need, and also tests if the token
 	// works
-	pi := oura.PersonalInfo{}
-	err = oura.SearchDocs(Cfg, un, "personal_info", &pi)
+	pi, err := oura.FetchPersonalInfo(Cfg, un)
 	if err != nil {
 		sendError(w, fmt.Sprintf("failed to fetch personal_info: %v", err))
 		return
@@ -144,11 +144,13 @@ func handleAuthCode(w http.ResponseWriter, r *http.Request,
 		un, pi.ID, pi.Email)
 	Cfg.UserTokens.StorePersonalInfo(un, &pi)
 	http.Redirect(w, r, "home", http.StatusTemporaryRedirect)
-	pollChan <- un
+	// the poller could be busy with a long backfill, and the new user
+	// shouldn't have to sit there waiting for it
+	go func() { pollChan <- un }()
 }
 
 func handleEvent(w http.ResponseWriter, r *http.Request,
-	sink chan<- oura.EventNotification, client_secret string) {
+	queue *oura.EventQueue, client_secret string) {
 	switch r.Method {
 	case "GET":
 		// this is how they verify that you are listening at subscription
@@ -224,16 +226,96 @@ func handleEvent(w http.ResponseWriter, r *http.Request,
 			writeLogErr(w, msg)
 			return
 		}
+		// if it isn't on disk, don't say we got it, and Oura will send it
+		// again
+		if err = queue.Enqueue(event); err != nil {
+			msg := fmt.Sprintf("can't queue event: %s", err)
+			log.Printf(msg)
+			w.WriteHeader(http.StatusInternalServerError)
+			writeLogErr(w, msg)
+			return
+		}
 		w.Header().Set("Content-type", "text/plain")
 		w.WriteHeader(http.StatusOK)
 		writeLogErr(w, "Thanks Chief!")
-		sink <- event
 	default:
 		log.Printf("weird HTTP method: %s", r.Method)
 		w.WriteHeader(http.StatusBadRequest)
 	}
 }
 
+// checkAdmin makes sure the request has "Authorization: Bearer xxx"
+// with the AdminToken.  Only the header will do, since query strings
+// end up in the log.  If there is no AdminToken in the config, nobody
+// gets in.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	tok := strings.TrimPrefix(auth, "Bearer ")
+	if len(Cfg.AdminToken) == 0 || len(tok) == len(auth) ||
+		subtle.ConstantTimeCompare([]byte(tok), []byte(Cfg.AdminToken)) != 1 {
+		log.Printf("rejected admin request from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		writeLogErr(w, "forbidden")
+		return false
+	}
+	return true
+}
+
+func handleBackfill(w http.ResponseWriter, r *http.Request,
+	sink chan<- backfillRequest) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeLogErr(w, "use POST\n")
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	req := backfillRequest{
+		user:  r.FormValue("user"),
+		types: splitTypes(r.FormValue("types")),
+	}
+	if !Cfg.UserTokens.NameIsTaken(req.user) {
+		sendError(w, fmt.Sprintf("no such user: %s", req.user))
+		return
+	}
+	// the dates are the user's dates, the same as a search uses
+	loc := Cfg.UserLocation(req.user)
+	var err error
+	if req.start, err = parseDate(r.FormValue("start"), loc); err != nil {
+		sendError(w, fmt.Sprintf("bad start: %s", err))
+		return
+	}
+	req.end = time.Now()
+	if len(r.FormValue("end")) > 0 {
+		if req.end, err = parseDate(r.FormValue("end"), loc); err != nil {
+			sendError(w, fmt.Sprintf("bad end: %s", err))
+			return
+		}
+	}
+	select {
+	case sink <- req:
+		log.Printf("queued backfill for %s from %s to %s", req.user,
+			req.start.Format("2006-01-02"), req.end.Format("2006-01-02"))
+		w.Header().Set("Content-type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		writeLogErr(w, "backfill queued; watch the log for progress\n")
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeLogErr(w, "too many backfills queued already\n")
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// this is only populated if there is a "prometheus" sink configured
+	w.Header().Set("Content-type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	if err := Cfg.Latest.WriteProm(w); err != nil {
+		log.Printf("can't write to http response: %s", err)
+	}
+}
+
 func writeLogErr(w io.Writer, s string) {
 	_, err := io.WriteString(w, s)
 	if err != nil {
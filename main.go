@@ -118,7 +118,11 @@ func sigHandler(source <-chan os.Signal, sink chan<- string) {
 		switch sig {
 		case syscall.SIGHUP:
 			log.Printf("received SIGHUP, rereading config files and reopening logs")
+			// hang on to the values that /metrics is serving, or else it
+			// will be empty until the next poll
+			latest := Cfg.Latest
 			*Cfg = oura.LoadClientConfig(*ClientFile)
+			Cfg.Latest = latest
 			Cfg.Reconnect = true
 		case syscall.SIGUSR1:
 			log.Printf("received SIGUSR1, re-polling documents and subscriptions")
@@ -132,6 +136,22 @@ func sigHandler(source <-chan os.Signal, sink chan<- string) {
 
 func main() {
 	flag.Parse()
+
+	// the one-shot commands, which do their thing and exit
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "replay":
+			replayMain(flag.Args()[1:])
+		case "backfill":
+			backfillMain(flag.Args()[1:])
+		case "redrive":
+			redriveMain(flag.Args()[1:])
+		default:
+			log.Fatalf("unknown command %s", flag.Arg(0))
+		}
+		return
+	}
+
 	log.Println("=======> start")
 
 	// set up oauth, which means retrieving the client secrets and the
@@ -146,24 +166,32 @@ func main() {
 	go oura.StoreObservations(Cfg, observationChan)
 
 	// create a channel where anyone can put a username and it will get
-	// all its documents re-searched.
+	// all its documents re-searched.  backfills go through the same
+	// goroutine, so that they never refresh a user's token at the same
+	// time as a poll does.
 	pollChan := make(chan string)
+	backfillChan := make(chan backfillRequest, 10)
 	go func() {
-		for p := range pollChan {
-			oura.SearchAll(Cfg, p, observationChan)
+		for {
+			select {
+			case p := <-pollChan:
+				oura.SearchAll(Cfg, p, observationChan)
+			case b := <-backfillChan:
+				_, err := oura.Backfill(Cfg, b.user, b.start, b.end, b.types,
+					observationChan)
+				if err != nil {
+					log.Printf("backfill for %s incomplete: %s", b.user, err)
+				}
+			}
 		}
 	}()
 
 	// webhook events are nothing more than notifications that a new
-	// document is ready.  the webhook callback handler will chuck the
-	// incoming document IDs into a channel, and they will be fetched
-	// serially.
-	eventChan := make(chan oura.EventNotification)
-	go func() {
-		for e := range eventChan {
-			oura.ProcessEvent(Cfg, e, observationChan)
-		}
-	}()
+	// document is ready.  the webhook callback handler will write the
+	// incoming document IDs into the event queue, and they will be
+	// fetched serially, and retried if that fails.
+	eventQueue := oura.MakeEventQueue(Cfg)
+	go eventQueue.Run(observationChan)
 
 	mux := http.NewServeMux()
 	/* bizarre mystery: with a proxy_pass match on /tsbridge/, nginx
@@ -179,7 +207,11 @@ func main() {
 		handleAuthCode(w, r, pollChan)
 	})
 	mux.HandleFunc("/event", func(w http.ResponseWriter, r *http.Request) {
-		handleEvent(w, r, eventChan, Cfg.OauthConfig.ClientSecret)
+		handleEvent(w, r, eventQueue, Cfg.OauthConfig.ClientSecret)
+	})
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/admin/backfill", func(w http.ResponseWriter, r *http.Request) {
+		handleBackfill(w, r, backfillChan)
 	})
 	srv := startHttp(Cfg.ListenAddr, *mux)
 
@@ -0,0 +1,95 @@
+package oura
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Backfill searches for every document of the given types (or all of
+// them, if types is empty) between start and end, a chunk at a time,
+// and sends them down sink.  Unlike SearchAll, it does not count as
+// using the token, so LastUse stays where it was.  It goes as fast as
+// the API lets it; when the sinks can't keep up, sends on sink block
+// (see StoreObservations), which slows it down instead of losing data.
+func Backfill(cfg *ClientConfig, name string, start time.Time,
+	end time.Time, types []string, sink chan<- Observation) (int, error) {
+
+	if !cfg.UserTokens.NameIsTaken(name) {
+		return 0, fmt.Errorf("no token by the name %s", name)
+	}
+	if !start.Before(end) {
+		return 0, fmt.Errorf("start %s is not before end %s",
+			start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+	wanted := make(map[string]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	for t := range wanted {
+		if findDocType(t) == nil {
+			return 0, fmt.Errorf("unknown document type %s", t)
+		}
+	}
+
+	sent_count := 0
+	fail_count := 0 // counting document types, not chunks
+	for i := range docTypes {
+		dt := &docTypes[i]
+		if len(wanted) > 0 && !wanted[dt.endpoint] {
+			continue
+		}
+		// asking for a type by name gets it even if it is turned off
+		if len(wanted) == 0 && !cfg.docTypeEnabled(dt) {
+			continue
+		}
+		// but never one the user hasn't opted into
+		if !cfg.userWants(name, dt) {
+			continue
+		}
+		i, err := searchChunks(cfg, name, dt, start, end, sink, true)
+		sent_count += i
+		if err != nil {
+			fail_count += 1
+		}
+	}
+	log.Printf("backfill for %s sent %d observations, %d types had errors",
+		name, sent_count, fail_count)
+	if fail_count > 0 {
+		return sent_count, fmt.Errorf("%d document types had errors",
+			fail_count)
+	}
+	return sent_count, nil
+}
+
+// searchChunks searches for dt over the range from start to end, a
+// chunk of dt.chunkDays at a time, since Oura limits how wide a date
+// range one search can cover.  If keep_going, a failed chunk doesn't
+// stop the rest, on the theory that one bad chunk shouldn't spoil a
+// long backfill.  The error is the last one, if any.
+func searchChunks(cfg *ClientConfig, name string, dt *docType,
+	start time.Time, end time.Time, sink chan<- Observation,
+	keep_going bool) (int, error) {
+	var last_err error
+	sent_count := 0
+	chunk := 24 * time.Hour * time.Duration(dt.chunkDays)
+	for t0 := start; t0.Before(end); t0 = t0.Add(chunk) {
+		t1 := t0.Add(chunk)
+		if t1.After(end) {
+			t1 = end
+		}
+		if keep_going {
+			log.Printf("backfilling %s %s from %s to %s", name, dt.endpoint,
+				t0.Format("2006-01-02"), t1.Format("2006-01-02"))
+		}
+		i, err := dt.search(cfg, dt, name, t0, t1, sink)
+		sent_count += i
+		if err != nil {
+			last_err = err
+			if !keep_going {
+				break
+			}
+		}
+	}
+	return sent_count, last_err
+}
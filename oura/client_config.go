@@ -19,10 +19,34 @@ type ClientConfig struct {
 	LocalDataLog   string
 	GraphiteServer string
 	GraphitePrefix string
+	Sinks          []SinkConfig // if empty, LocalDataLog and GraphiteServer
+	// turn document types on or off by endpoint name, e.g.
+	// {"heartrate": false}
+	DocTypes map[string]bool
+	// tag codes (like "tag_generic_alcohol") that get recorded for
+	// everyone.  No other tags are, except the ones a user opts into.
+	TagAllowList []string
+	// lower bounds in bpm of heart rate zones 1, 2, 3...; if empty, the
+	// zones are worked out from each user's age
+	HeartRateZones []int
+	// more values for enums.go, e.g. {"workout.intensity": {"max": 4}}
+	Enums map[string]map[string]float32
+	// local time of day ("15:04") for documents that only have a date;
+	// midnight if empty
+	DayAnchor      string
 	TimeoutSeconds int
-	UserCredsFile  string
-	ListenAddr     string
-	OauthConfig    oauth2.Config
+	// limits for talking to the Oura API, shared across all users
+	RequestsPerMinute int
+	MaxRetries        int // for failures that look temporary
+	RetryMaxSeconds   int // longest we will sleep before a retry; 0 is 300
+	UserCredsFile     string
+	ListenAddr        string
+	AdminToken        string // for the /admin/ handlers; they are off if empty
+	// webhook events wait here until they are processed (eventqueue.go)
+	EventQueueDir       string
+	EventDeadLetterFile string
+	EventMaxTries       int
+	OauthConfig         oauth2.Config
 	// {
 	//   RedirectURL  string // ??
 	//   ClientID     string
@@ -36,6 +60,10 @@ type ClientConfig struct {
 	Verifier      string          `json:"-"`
 	UserTokens    UserTokenSet    `json:"-"`
 	Subscriptions SubscriptionSet `json:"-"`
+	Latest        *LatestSet      `json:"-"`
+	limiter       *rateLimiter
+	enums         map[string]map[string]float32
+	dayAnchor     time.Duration
 }
 
 func validURL(u string) *url.URL {
@@ -71,14 +99,22 @@ func LoadClientConfig(fname string) ClientConfig {
 		GraphiteServer: "",
 		GraphitePrefix: "bio.",
 		TimeoutSeconds: 10,
-		UserCredsFile:  "user_creds.json",
-		ListenAddr:     "127.0.0.1:8000",
+		// Oura says 5000 requests per 5 minutes, but there is no reason
+		// to get anywhere near that
+		RequestsPerMinute:   300,
+		MaxRetries:          4,
+		RetryMaxSeconds:     defaultRetryMaxSeconds,
+		UserCredsFile:       "user_creds.json",
+		ListenAddr:          "127.0.0.1:8000",
+		EventQueueDir:       "events",
+		EventDeadLetterFile: "events.dead",
+		EventMaxTries:       8,
 		OauthConfig: oauth2.Config{
 			RedirectURL:  "TODO",
 			ClientID:     "TODO",
 			ClientSecret: "TODO",
 			Scopes: []string{"email", "personal", "daily", "heartrate",
-				"workout", "spo2", "stress"},
+				"workout", "session", "spo2", "stress"},
 			Endpoint: oauth2.Endpoint{
 				AuthURL:       "https://cloud.ouraring.com/oauth/authorize",
 				DeviceAuthURL: "unused",
@@ -92,8 +128,17 @@ func LoadClientConfig(fname string) ClientConfig {
 		log.Fatalf("edit %s, then try running again", fname)
 	}
 	jdump.ParseJsonOrDie(fname, &cc)
+	if err := cc.checkDocTypes(); err != nil {
+		log.Fatalf("%s: %s", fname, err)
+	}
 	cc.UserTokens = MakeUserTokenSet(cc.UserCredsFile)
 	cc.Subscriptions = MakeSubscriptionSet()
+	cc.Latest = MakeLatestSet()
+	cc.limiter = makeRateLimiter(cc.RequestsPerMinute)
+	cc.loadEnums()
+	if cc.dayAnchor, err = parseDayAnchor(cc.DayAnchor); err != nil {
+		log.Fatalf("%s: %s", fname, err)
+	}
 	return cc
 }
 
@@ -0,0 +1,53 @@
+package oura
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// testConfig has one user, bob (Oura user id u1), with UTC offset
+// offset, and talks to api for the Oura API if it isn't nil.
+func testConfig(t *testing.T, offset int, api http.Handler) *ClientConfig {
+	cfg := &ClientConfig{
+		TimeoutSeconds:  10,
+		MaxRetries:      0,
+		RetryMaxSeconds: 1,
+	}
+	cfg.UserTokens = UserTokenSet{
+		File: t.TempDir() + "/users.json",
+		tokens: map[string]UserToken{
+			"bob": {
+				Name: "bob",
+				PI:   PersonalInfo{ID: "u1"},
+				OauthToken: oauth2.Token{
+					AccessToken: "xyzzy",
+					TokenType:   "Bearer",
+					Expiry:      time.Now().Add(time.Hour),
+				},
+				LastUse:   time.Now(),
+				UtcOffset: &offset,
+			},
+		},
+	}
+	if api != nil {
+		srv := httptest.NewServer(api)
+		t.Cleanup(srv.Close)
+		cfg.ApiBaseURL = srv.URL
+	}
+	cfg.loadEnums()
+	return cfg
+}
+
+// collect closes sink and sorts what was in it by metric name
+func collect(sink chan Observation) map[string][]Observation {
+	close(sink)
+	got := make(map[string][]Observation)
+	for obs := range sink {
+		got[obs.Field] = append(got[obs.Field], obs)
+	}
+	return got
+}
@@ -0,0 +1,130 @@
+package oura
+
+import (
+	"log"
+	"sync"
+)
+
+// Lots of document fields are a string from a short list, like
+// "easy", "moderate", "hard".  To graph them, we turn them into
+// numbers with this table.  The key is the document prefix and the
+// field name, the same as the metric name that comes out.  Any string
+// field that sendFields finds in here gets sent automatically; the
+// extract functions look up the rest with emitter.enum.
+//
+// The Enums setting in the config file is merged over the top of this,
+// so you can add values that Oura comes up with later, or renumber
+// them, without waiting for a new release.
+
+var defaultEnums = map[string]map[string]float32{
+	"resilience.level": {
+		"limited":     1,
+		"adequate":    2,
+		"solid":       3, // I am assuming the words above "adequate" look like this
+		"strong":      4, // because I have never seen them lol
+		"exceptional": 5,
+	},
+	"stress.day_summary": {
+		"restored":  1,
+		"normal":    2,
+		"stressful": 3,
+	},
+	"sleep.type": {
+		"deleted":    0,
+		"rest":       1,
+		"late_nap":   2,
+		"sleep":      3,
+		"long_sleep": 4,
+	},
+	"workout.intensity": {
+		"easy":     1,
+		"moderate": 2,
+		"hard":     3,
+	},
+	"session.type": {
+		"breathing":   1,
+		"meditation":  2,
+		"nap":         3,
+		"relaxation":  4,
+		"rest":        5,
+		"body_status": 6,
+	},
+	"sleep_time.status": {
+		"not_enough_nights":        1,
+		"not_enough_recent_nights": 2,
+		"bad_sleep_quality":        3,
+		"only_recommended_found":   4,
+		"optimal_found":            5,
+	},
+	"sleep_time.recommendation": {
+		"improve_efficiency":     1,
+		"earlier_bedtime":        2,
+		"later_bedtime":          3,
+		"earlier_wake_up_time":   4,
+		"later_wake_up_time":     5,
+		"follow_optimal_bedtime": 6,
+	},
+	"cycle.phase": {
+		"menstrual":  1,
+		"follicular": 2,
+		"ovulation":  3,
+		"luteal":     4,
+	},
+	"ring.hardware_type": {
+		"gen1":  1,
+		"gen2":  2,
+		"gen2m": 3,
+		"gen3":  4,
+		"gen4":  5,
+	},
+}
+
+// loadEnums merges the Enums setting over defaultEnums
+func (cfg *ClientConfig) loadEnums() {
+	enums := make(map[string]map[string]float32)
+	for k, values := range defaultEnums {
+		enums[k] = make(map[string]float32)
+		for s, v := range values {
+			enums[k][s] = v
+		}
+	}
+	for k, values := range cfg.Enums {
+		if enums[k] == nil {
+			enums[k] = make(map[string]float32)
+		}
+		for s, v := range values {
+			enums[k][s] = v
+		}
+	}
+	cfg.enums = enums
+}
+
+// so that an unknown value is only logged the first time
+var unknownEnums = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// enumValue looks up value in the table for key.  If key isn't in the
+// table, that is not an enum, and not worth complaining about.
+func (cfg *ClientConfig) enumValue(key string, value string) (float32, bool) {
+	enums := cfg.enums
+	if enums == nil {
+		enums = defaultEnums
+	}
+	values, ok := enums[key]
+	if !ok || len(value) == 0 {
+		return 0, false
+	}
+	v, ok := values[value]
+	if !ok {
+		unknownEnums.Lock()
+		if !unknownEnums.seen[key+"="+value] {
+			unknownEnums.seen[key+"="+value] = true
+			log.Printf("unknown value %s for %s; add it to Enums in the config",
+				value, key)
+		}
+		unknownEnums.Unlock()
+	}
+	return v, ok
+}
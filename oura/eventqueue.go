@@ -0,0 +1,266 @@
+package oura
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Webhook notifications go into a directory, one file each, before we
+// tell Oura we got them.  That way an event survives a restart, and if
+// fetching the document fails (Oura is down, the token won't refresh)
+// we can try again later instead of losing it.  An event that fails
+// EventMaxTries times goes to the EventDeadLetterFile, one JSON object
+// per line, where you can look at it and put it back with "redrive".
+
+type QueuedEvent struct {
+	Event     EventNotification
+	Tries     int
+	NextTry   time.Time
+	LastError string
+}
+
+type EventQueue struct {
+	cfg  *ClientConfig
+	wake chan struct{}
+}
+
+const eventRetryBaseDelay = 30 * time.Second
+const eventRetryMaxDelay = 6 * time.Hour
+
+// the worker looks at the directory at least this often, in case
+// someone (like redrive) put something there behind its back
+const eventQueueIdle = time.Minute
+
+func MakeEventQueue(cfg *ClientConfig) *EventQueue {
+	if err := os.MkdirAll(cfg.EventQueueDir, 0755); err != nil {
+		log.Fatalf("can't create EventQueueDir %s: %s", cfg.EventQueueDir, err)
+	}
+	return &EventQueue{cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue returns after the event is safely on disk, or with an error
+// if it isn't.  The worker is poked to come and get it.
+func (q *EventQueue) Enqueue(event EventNotification) error {
+	// the names sort in arrival order
+	name := fmt.Sprintf("%020d-%08x.json", time.Now().UnixNano(),
+		rand.Uint32())
+	err := writeQueuedEvent(filepath.Join(q.cfg.EventQueueDir, name),
+		&QueuedEvent{Event: event})
+	if err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// it's already been poked
+	}
+	return nil
+}
+
+// writeQueuedEvent writes qe to a temp file and renames it to fname,
+// so that nobody ever sees half of a file, even if we crash.
+func writeQueuedEvent(fname string, qe *QueuedEvent) error {
+	buf, err := json.Marshal(qe)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fname), ".new-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// queueFiles is the events in the directory, oldest first
+func (q *EventQueue) queueFiles() []string {
+	entries, err := os.ReadDir(q.cfg.EventQueueDir)
+	if err != nil {
+		log.Printf("can't read EventQueueDir %s: %s", q.cfg.EventQueueDir, err)
+		return nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, ent := range entries {
+		n := ent.Name()
+		if ent.IsDir() || strings.HasPrefix(n, ".") ||
+			!strings.HasSuffix(n, ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(q.cfg.EventQueueDir, n))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Run processes events forever, one at a time.  Whatever was left in
+// the directory from last time goes first.
+func (q *EventQueue) Run(sink chan<- Observation) {
+	if n := len(q.queueFiles()); n > 0 {
+		log.Printf("event queue %s has %d events left over",
+			q.cfg.EventQueueDir, n)
+	}
+	for {
+		next := q.runOnce(sink)
+		wait := eventQueueIdle
+		if !next.IsZero() {
+			if w := time.Until(next); w < wait {
+				wait = w
+			}
+		}
+		if wait <= 0 {
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runOnce tries every event that is due, and returns when the next one
+// not yet due is, or zero if there isn't one.
+func (q *EventQueue) runOnce(sink chan<- Observation) time.Time {
+	var next time.Time
+	for _, fname := range q.queueFiles() {
+		qe := QueuedEvent{}
+		buf, err := os.ReadFile(fname)
+		if err == nil {
+			err = json.Unmarshal(buf, &qe)
+		}
+		if err != nil {
+			// trying again won't help this one
+			log.Printf("unreadable queued event %s: %s", fname, err)
+			q.deadLetter(fname, nil, buf)
+			continue
+		}
+		if time.Now().Before(qe.NextTry) {
+			if next.IsZero() || qe.NextTry.Before(next) {
+				next = qe.NextTry
+			}
+			continue
+		}
+		err = ProcessEvent(q.cfg, qe.Event, sink)
+		if err == nil {
+			if err = os.Remove(fname); err != nil {
+				log.Printf("can't remove queued event %s: %s", fname, err)
+			}
+			continue
+		}
+		qe.Tries += 1
+		qe.LastError = err.Error()
+		if isPermanent(err) {
+			// no sense trying again, but somebody should look at it
+			log.Printf("giving up on %s/%s: %s", qe.Event.Data_type,
+				qe.Event.Object_id, err)
+			q.deadLetter(fname, &qe, nil)
+			continue
+		}
+		if qe.Tries >= q.cfg.EventMaxTries {
+			log.Printf("giving up on %s/%s after %d tries",
+				qe.Event.Data_type, qe.Event.Object_id, qe.Tries)
+			q.deadLetter(fname, &qe, nil)
+			continue
+		}
+		backoff := eventRetryBaseDelay << (qe.Tries - 1)
+		if backoff <= 0 || backoff > eventRetryMaxDelay {
+			backoff = eventRetryMaxDelay
+		}
+		backoff += time.Duration(rand.Int63n(int64(backoff) / 2))
+		qe.NextTry = time.Now().Add(backoff)
+		log.Printf("%s/%s failed, try %d of %d in %s",
+			qe.Event.Data_type, qe.Event.Object_id, qe.Tries,
+			q.cfg.EventMaxTries, backoff.Round(time.Second))
+		if err = writeQueuedEvent(fname, &qe); err != nil {
+			log.Printf("can't update queued event %s: %s", fname, err)
+		}
+		if next.IsZero() || qe.NextTry.Before(next) {
+			next = qe.NextTry
+		}
+	}
+	return next
+}
+
+// deadLetter moves an event from the queue to the end of the dead
+// letter file.  If we couldn't parse it, raw goes there instead.  If
+// the dead letter file can't be written, the event stays in the queue
+// rather than vanish.
+func (q *EventQueue) deadLetter(fname string, qe *QueuedEvent, raw []byte) {
+	var err error
+	line := raw
+	if qe != nil {
+		if line, err = json.Marshal(qe); err != nil {
+			log.Printf("can't encode dead letter %s: %s", fname, err)
+			return
+		}
+	}
+	line = append([]byte(strings.TrimSpace(string(line))), '\n')
+	f, err := os.OpenFile(q.cfg.EventDeadLetterFile,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("can't open EventDeadLetterFile %s: %s",
+			q.cfg.EventDeadLetterFile, err)
+		return
+	}
+	_, err = f.Write(line)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Printf("failed write to EventDeadLetterFile %s: %s",
+			q.cfg.EventDeadLetterFile, err)
+		return
+	}
+	if err = os.Remove(fname); err != nil {
+		log.Printf("can't remove queued event %s: %s", fname, err)
+	}
+}
+
+// ReadDeadLetters parses the dead letter file.  Lines that don't parse
+// come back in bad, so that they aren't thrown away.
+func ReadDeadLetters(fname string) (events []QueuedEvent, bad []string,
+	err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		qe := QueuedEvent{}
+		if json.Unmarshal([]byte(line), &qe) != nil ||
+			len(qe.Event.Object_id) == 0 {
+			bad = append(bad, line)
+			continue
+		}
+		events = append(events, qe)
+	}
+	return events, bad, scanner.Err()
+}
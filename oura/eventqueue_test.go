@@ -0,0 +1,139 @@
+package oura
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventQueueRetry(t *testing.T) {
+	status := map[string]int{}
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if code := status[id]; code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"%s","day":"2024-08-11",`+
+			`"spo2_percentage":{"average":97.5}}`, id)
+	})
+	cfg := testConfig(t, 0, api)
+	dir := t.TempDir()
+	cfg.EventQueueDir = filepath.Join(dir, "events")
+	cfg.EventDeadLetterFile = filepath.Join(dir, "events.dead")
+	cfg.EventMaxTries = 2
+	q := MakeEventQueue(cfg)
+
+	status["gone"] = http.StatusNotFound
+	status["forbidden"] = http.StatusForbidden
+	status["unauth"] = http.StatusUnauthorized
+	status["down"] = http.StatusServiceUnavailable
+	for _, id := range []string{"ok", "gone", "forbidden", "unauth",
+		"down"} {
+		err := q.Enqueue(EventNotification{Event_type: "update",
+			Data_type: "daily_spo2", Object_id: id, User_id: "u1"})
+		if err != nil {
+			t.Fatalf("Enqueue: %s", err)
+		}
+	}
+
+	sink := make(chan Observation, 100)
+	next := q.runOnce(sink)
+	got := collect(sink)
+	if len(got["spo2.daily_average"]) != 1 {
+		t.Errorf("sent %v, want one spo2.daily_average", got)
+	}
+	// "ok" is done, "gone" was deleted, and "forbidden" is never going
+	// to work, so only "unauth" and "down" are left, waiting for their
+	// retries
+	queued := map[string]QueuedEvent{}
+	for _, fname := range q.queueFiles() {
+		qe := QueuedEvent{}
+		buf, _ := os.ReadFile(fname)
+		json.Unmarshal(buf, &qe)
+		queued[qe.Event.Object_id] = qe
+	}
+	if len(queued) != 2 {
+		t.Fatalf("queue has %v, want unauth and down", queued)
+	}
+	var first time.Time
+	for _, id := range []string{"unauth", "down"} {
+		qe := queued[id]
+		if qe.Tries != 1 || len(qe.LastError) == 0 {
+			t.Errorf("queued event %s is %+v", id, qe)
+		}
+		if first.IsZero() || qe.NextTry.Before(first) {
+			first = qe.NextTry
+		}
+	}
+	if wait := time.Until(next); wait < eventRetryBaseDelay/2 ||
+		!next.Equal(first) {
+		t.Errorf("next try in %s, at %s", wait, first)
+	}
+	dead, bad, err := ReadDeadLetters(cfg.EventDeadLetterFile)
+	if err != nil || len(bad) != 0 || len(dead) != 1 ||
+		dead[0].Event.Object_id != "forbidden" || dead[0].Tries != 1 {
+		t.Fatalf("dead letters %+v, bad %v, err %v", dead, bad, err)
+	}
+
+	// not due yet, so nothing happens
+	sink = make(chan Observation, 100)
+	q.runOnce(sink)
+	collect(sink)
+	if files := q.queueFiles(); len(files) != 2 {
+		t.Fatalf("queue has %d events, want 2", len(files))
+	}
+
+	// make them due, and they fail for the last time
+	for _, fname := range q.queueFiles() {
+		qe := QueuedEvent{}
+		buf, _ := os.ReadFile(fname)
+		json.Unmarshal(buf, &qe)
+		qe.NextTry = time.Time{}
+		writeQueuedEvent(fname, &qe)
+	}
+	sink = make(chan Observation, 100)
+	q.runOnce(sink)
+	collect(sink)
+	if files := q.queueFiles(); len(files) != 0 {
+		t.Errorf("queue has %d events, want 0", len(files))
+	}
+	dead, bad, err = ReadDeadLetters(cfg.EventDeadLetterFile)
+	if err != nil || len(bad) != 0 || len(dead) != 3 {
+		t.Fatalf("dead letters %v, bad %v, err %v", dead, bad, err)
+	}
+	for _, qe := range dead[1:] {
+		if (qe.Event.Object_id != "unauth" && qe.Event.Object_id != "down") ||
+			qe.Tries != 2 {
+			t.Errorf("dead letter is %+v", qe)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusNotFound:            true,
+		http.StatusBadRequest:          true,
+		http.StatusForbidden:           true,
+		http.StatusUnauthorized:        false,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+		http.StatusBadGateway:          false,
+	} {
+		if got := isPermanent(statusError{code: code}); got != want {
+			t.Errorf("isPermanent(%d) is %v", code, got)
+		}
+	}
+	if isPermanent(fmt.Errorf("connection refused")) {
+		t.Errorf("a network error is not permanent")
+	}
+	if !isPermanent(fmt.Errorf("wrapped: %w", statusError{code: 404})) {
+		t.Errorf("a wrapped 404 is permanent")
+	}
+}
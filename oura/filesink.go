@@ -0,0 +1,52 @@
+package oura
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+)
+
+// fileSink appends lines to a local file, which can be ingested
+// somewhere else later.  The lines are graphite plaintext unless
+// format says otherwise.
+type fileSink struct {
+	path   string
+	format func(Observation) string
+	f      *os.File
+	w      *bufio.Writer
+}
+
+func (s *fileSink) Open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("opened local log file %s", s.path)
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (s *fileSink) Write(obs Observation) error {
+	_, err := io.WriteString(s.w, s.format(obs))
+	return err
+}
+
+func (s *fileSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.w.Flush()
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *fileSink) Healthy() bool {
+	return s.f != nil
+}
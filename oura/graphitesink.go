@@ -0,0 +1,50 @@
+package oura
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+)
+
+// graphiteSink speaks the graphite plaintext protocol over TCP.
+type graphiteSink struct {
+	server string
+	prefix string
+	conn   net.Conn
+	w      *bufio.Writer
+}
+
+func (s *graphiteSink) Open() error {
+	conn, err := net.Dial("tcp", s.server)
+	if err != nil {
+		return err
+	}
+	log.Printf("connected to graphite receiver at %s", s.server)
+	s.conn = conn
+	s.w = bufio.NewWriter(conn)
+	return nil
+}
+
+func (s *graphiteSink) Write(obs Observation) error {
+	_, err := io.WriteString(s.w, graphiteLine(s.prefix, obs))
+	return err
+}
+
+func (s *graphiteSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *graphiteSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.w.Flush()
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *graphiteSink) Healthy() bool {
+	return s.conn != nil
+}
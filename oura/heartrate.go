@@ -0,0 +1,135 @@
+package oura
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// heartrate documents are one reading each, every few minutes (or
+// seconds, during a workout), which is too fine to be much use by
+// itself.  heartrateDaily adds up each day of readings into:
+//
+//	hr.daily.{min,max,mean}
+//	hr.daily.zone.N            minutes in heart rate zone N
+//	hr.daily.source.S.minutes  minutes of readings from source S
+//	hr.daily.source.S.mean     (awake, rest, sleep, workout, ...)
+//
+// all at the dayTime of the day.  Days are in the user's location,
+// which is also how SearchPages rounds the search off, so a search
+// never covers part of a day unless it is today.
+
+// a reading counts until the next one, but not longer than this, so
+// that taking the ring off doesn't count as time in a zone.
+const maxHeartrateGap = 5 * time.Minute
+
+// with no HeartRateZones setting, zone N starts at zoneFractions[N-1]
+// of the age-predicted maximum heart rate
+var zoneFractions = []float32{0.5, 0.6, 0.7, 0.8, 0.9}
+
+// heartrateZones is the lower bound of each zone, starting at zone 1.
+// Below that is zone 0.  If there's no setting and no age, there are
+// no zones.
+func heartrateZones(cfg *ClientConfig, user string) []int {
+	if len(cfg.HeartRateZones) > 0 {
+		return cfg.HeartRateZones
+	}
+	age := cfg.UserTokens.GetPersonalInfo(user).Age
+	if age <= 0 {
+		return nil
+	}
+	max_hr := float32(220 - age)
+	zones := make([]int, len(zoneFractions))
+	for i, f := range zoneFractions {
+		zones[i] = int(f * max_hr)
+	}
+	return zones
+}
+
+type hrSummary struct {
+	count   int
+	sum     int
+	min     int
+	max     int
+	zones   []float32 // minutes
+	sources map[string]*hrSource
+}
+
+type hrSource struct {
+	count   int
+	sum     int
+	minutes float32
+}
+
+func heartrateDaily(docs []heartrateInstant, e *emitter) {
+	zones := heartrateZones(e.cfg, e.username)
+	loc := e.cfg.UserLocation(e.username)
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Timestamp.Before(docs[j].Timestamp)
+	})
+	days := make([]time.Time, 0)
+	summaries := make(map[time.Time]*hrSummary)
+	for i, hr := range docs {
+		y, m, d := hr.Timestamp.In(loc).Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, loc).Add(e.cfg.dayAnchor)
+		sum := summaries[day]
+		if sum == nil {
+			sum = &hrSummary{
+				min:     hr.Bpm,
+				max:     hr.Bpm,
+				zones:   make([]float32, len(zones)+1),
+				sources: make(map[string]*hrSource),
+			}
+			summaries[day] = sum
+			days = append(days, day)
+		}
+		sum.count += 1
+		sum.sum += hr.Bpm
+		if hr.Bpm < sum.min {
+			sum.min = hr.Bpm
+		}
+		if hr.Bpm > sum.max {
+			sum.max = hr.Bpm
+		}
+		gap := maxHeartrateGap
+		if i+1 < len(docs) {
+			if g := docs[i+1].Timestamp.Sub(hr.Timestamp); g < gap {
+				gap = g
+			}
+		}
+		minutes := float32(gap.Minutes())
+		zone := 0
+		for zone < len(zones) && hr.Bpm >= zones[zone] {
+			zone += 1
+		}
+		sum.zones[zone] += minutes
+		src := sum.sources[hr.Source]
+		if src == nil {
+			src = &hrSource{}
+			sum.sources[hr.Source] = src
+		}
+		src.count += 1
+		src.sum += hr.Bpm
+		src.minutes += minutes
+	}
+	for _, day := range days {
+		sum := summaries[day]
+		e.sendTs("daily.min", float32(sum.min), day)
+		e.sendTs("daily.max", float32(sum.max), day)
+		e.sendTs("daily.mean", float32(sum.sum)/float32(sum.count), day)
+		if len(zones) > 0 {
+			for i, minutes := range sum.zones {
+				e.sendTs(fmt.Sprintf("daily.zone.%d", i), minutes, day)
+			}
+		}
+		for name, src := range sum.sources {
+			name = metricSafe(name)
+			if len(name) == 0 {
+				name = "unknown"
+			}
+			e.sendTs("daily.source."+name+".minutes", src.minutes, day)
+			e.sendTs("daily.source."+name+".mean",
+				float32(src.sum)/float32(src.count), day)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package oura
+
+import (
+	"time"
+)
+
+// Some documents have a string with one digit per interval, which
+// sendFields passes through as a timeseries of the digits.  That is
+// a hypnogram, and what you actually want to graph is how long was
+// spent at each level, which is painful to work out in graphite.
+
+// the digits of sleep_phase_5_min
+var sleepStages = []stepClass{
+	{'1', "deep"},
+	{'2', "light"},
+	{'3', "rem"},
+	{'4', "awake"},
+}
+
+// the digits of class_5_min
+var activityClasses = []stepClass{
+	{'0', "non_wear"},
+	{'1', "rest"},
+	{'2', "inactive"},
+	{'3', "low"},
+	{'4', "medium"},
+	{'5', "high"},
+}
+
+type stepClass struct {
+	digit byte
+	name  string
+}
+
+// sendMinutesPerHour adds up the minutes at each level of hypnogram s
+// in each clock hour, and sends them as prefix.<name> at the top of
+// the hour.  Every level gets sent for every hour, even if it is 0,
+// so that stacked graphs come out right.  An interval that straddles
+// the hour counts toward the hour it started in.  The hours are clock
+// hours in the user's location, which aren't on the UTC hour for
+// everybody (India is +5:30).
+func sendMinutesPerHour(e *emitter, prefix string, s string, t0 time.Time,
+	step time.Duration, classes []stepClass) {
+	if len(s) == 0 || t0.IsZero() {
+		return
+	}
+	loc := e.cfg.UserLocation(e.username)
+	hours := make([]time.Time, 0)
+	minutes := make(map[time.Time]map[byte]float32)
+	for i := 0; i < len(s); i++ {
+		t := t0.Add(time.Duration(i) * step).In(loc)
+		h := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		if minutes[h] == nil {
+			minutes[h] = make(map[byte]float32)
+			hours = append(hours, h)
+		}
+		minutes[h][s[i]] += float32(step.Minutes())
+	}
+	for _, h := range hours {
+		for _, c := range classes {
+			e.sendTs(prefix+"."+c.name, minutes[h][c.digit], h)
+		}
+	}
+}
+
+func (sp sleepPeriod) extract(e *emitter) {
+	sendFields(sp, e)
+	s := sp.Sleep_phase_5_min
+	if len(s) == 0 {
+		return
+	}
+	sendMinutesPerHour(e, "stages", s, sp.Bedtime_start, 5*time.Minute,
+		sleepStages)
+	transitions := 0
+	for i := 1; i < len(s); i++ {
+		if s[i] != s[i-1] {
+			transitions += 1
+		}
+	}
+	e.send("stages.transitions", float32(transitions))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '1' {
+			// minutes from going to bed until the first deep sleep
+			e.send("stages.deep_latency", float32(5*i))
+			break
+		}
+	}
+}
+
+func (da dailyActivity) extract(e *emitter) {
+	sendFields(da, e)
+	sendMinutesPerHour(e, "class", da.Class_5_min, da.Timestamp,
+		5*time.Minute, activityClasses)
+}
@@ -0,0 +1,44 @@
+package oura
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinutesPerHourHalfHourOffset(t *testing.T) {
+	cfg := testConfig(t, 5*3600+1800, nil)
+	sink := make(chan Observation, 100)
+	e := &emitter{cfg: cfg, doc: "sleep", prefix: "sleep", username: "bob",
+		sink: sink}
+	// 23:50 local, then 2 intervals before midnight and 4 after
+	loc := time.FixedZone("", 5*3600+1800)
+	t0 := time.Date(2024, 8, 10, 23, 50, 0, 0, loc)
+	sendMinutesPerHour(e, "stages", "112222", t0, 5*time.Minute,
+		sleepStages)
+	got := collect(sink)
+
+	deep := got["sleep.stages.deep"]
+	light := got["sleep.stages.light"]
+	if len(deep) != 2 || len(light) != 2 {
+		t.Fatalf("got %d deep and %d light hours, want 2 each", len(deep),
+			len(light))
+	}
+	hours := []time.Time{
+		time.Date(2024, 8, 10, 23, 0, 0, 0, loc),
+		time.Date(2024, 8, 11, 0, 0, 0, 0, loc),
+	}
+	want_deep := []float32{10, 0}
+	want_light := []float32{0, 20}
+	for i, h := range hours {
+		if !deep[i].Timestamp.Equal(h) || !light[i].Timestamp.Equal(h) {
+			t.Errorf("hour %d is at %s, want %s", i, deep[i].Timestamp, h)
+		}
+		if deep[i].Value != want_deep[i] || light[i].Value != want_light[i] {
+			t.Errorf("hour %d: deep %v light %v, want %v and %v", i,
+				deep[i].Value, light[i].Value, want_deep[i], want_light[i])
+		}
+	}
+	if len(got["sleep.stages.rem"]) != 2 || got["sleep.stages.rem"][0].Value != 0 {
+		t.Errorf("empty levels should still be sent as 0")
+	}
+}
@@ -0,0 +1,116 @@
+package oura
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// influx line protocol looks like
+//
+//   oura,user=bob,doc_type=readiness contrib.hrv_balance=65 1719288000
+//
+// so instead of the dotted graphite path, the username and document
+// type become tags and the metric becomes the field key.
+
+var influxTagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+var influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+
+func influxLine(measurement string, obs Observation) string {
+	doc_type, metric := obs.SplitField()
+	return fmt.Sprintf("%s,user=%s,doc_type=%s %s=%f %d\n",
+		influxMeasurementEscaper.Replace(measurement),
+		influxTagEscaper.Replace(obs.Username),
+		influxTagEscaper.Replace(doc_type),
+		influxTagEscaper.Replace(metric),
+		obs.Value,
+		obs.Timestamp.Unix())
+}
+
+// influxSink batches lines and POSTs them to /api/v2/write.  A batch
+// goes out when it gets big, or when the worker runs out of
+// observations and calls Flush.
+type influxSink struct {
+	conf   SinkConfig
+	client *http.Client
+	buf    bytes.Buffer
+	lines  int
+}
+
+const influxBatchLines = 5000
+
+func (s *influxSink) writeURL() string {
+	u := validURL(s.conf.URL)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	params := url.Values{}
+	params.Add("org", s.conf.Org)
+	params.Add("bucket", s.conf.Bucket)
+	params.Add("precision", "s")
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (s *influxSink) Open() error {
+	s.client = &http.Client{Timeout: 30 * time.Second}
+	s.buf.Reset()
+	s.lines = 0
+	return nil
+}
+
+func (s *influxSink) Write(obs Observation) error {
+	s.buf.WriteString(influxLine(s.conf.measurement(), obs))
+	if s.lines += 1; s.lines >= influxBatchLines {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *influxSink) Flush() error {
+	if s.lines == 0 {
+		return nil
+	}
+	// whatever happens, this batch is done; if it failed, the worker
+	// will close us and try again later with new observations.
+	defer func() {
+		s.buf.Reset()
+		s.lines = 0
+	}()
+	req, err := http.NewRequest("POST", s.writeURL(),
+		bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(s.conf.Token) > 0 {
+		req.Header.Set("Authorization", "Token "+s.conf.Token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !isSuccess(res.StatusCode) {
+		body, _ := io.ReadAll(res.Body)
+		log.Printf("influxdb response body: %s", body)
+		return fmt.Errorf("influxdb http status was %s", res.Status)
+	}
+	return nil
+}
+
+func (s *influxSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.Flush()
+	s.client = nil
+	return err
+}
+
+func (s *influxSink) Healthy() bool {
+	return s.client != nil
+}
@@ -0,0 +1,115 @@
+package oura
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxSinkWrite(t *testing.T) {
+	var path, auth, ctype, body string
+	var query map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			query = r.URL.Query()
+			auth = r.Header.Get("Authorization")
+			ctype = r.Header.Get("Content-Type")
+			buf, _ := io.ReadAll(r.Body)
+			body = string(buf)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer srv.Close()
+
+	s := &influxSink{conf: SinkConfig{
+		Type:        "influxdb",
+		URL:         srv.URL + "/influx/",
+		Org:         "my org",
+		Bucket:      "oura",
+		Token:       "sekrit",
+		Measurement: "ring data",
+	}}
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	obs := []Observation{
+		{
+			Timestamp: time.Unix(1719288000, 0),
+			Username:  "bo b",
+			Field:     "sleep.contrib.a,b=c",
+			Value:     1.5,
+		},
+		{
+			Timestamp: time.Unix(1719288300, 0),
+			Username:  "bob",
+			Field:     "readiness.score",
+			Value:     80,
+		},
+	}
+	for _, o := range obs {
+		if err := s.Write(o); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if len(body) != 0 {
+		t.Errorf("sent a request before Flush: %q", body)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+
+	if path != "/influx/api/v2/write" {
+		t.Errorf("path is %s", path)
+	}
+	want_query := map[string]string{
+		"org":       "my org",
+		"bucket":    "oura",
+		"precision": "s",
+	}
+	for k, v := range want_query {
+		if len(query[k]) != 1 || query[k][0] != v {
+			t.Errorf("query %s is %v, want %s", k, query[k], v)
+		}
+	}
+	if auth != "Token sekrit" {
+		t.Errorf("Authorization is %q", auth)
+	}
+	if ctype != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type is %q", ctype)
+	}
+	want_body := `ring\ data,user=bo\ b,doc_type=sleep ` +
+		`contrib.a\,b\=c=1.500000 1719288000` + "\n" +
+		`ring\ data,user=bob,doc_type=readiness ` +
+		`score=80.000000 1719288300` + "\n"
+	if body != want_body {
+		t.Errorf("body is\n%s\nwant\n%s", body, want_body)
+	}
+
+	// nothing left to send
+	body = ""
+	if err := s.Flush(); err != nil || len(body) != 0 {
+		t.Errorf("second Flush sent %q, err %v", body, err)
+	}
+}
+
+func TestInfluxSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"code":"unauthorized"}`)
+		}))
+	defer srv.Close()
+
+	s := &influxSink{conf: SinkConfig{Type: "influxdb", URL: srv.URL}}
+	s.Open()
+	s.Write(Observation{Timestamp: time.Unix(0, 0), Username: "bob",
+		Field: "sleep.score", Value: 1})
+	if err := s.Flush(); err == nil {
+		t.Errorf("Flush succeeded on a 401")
+	}
+	if s.lines != 0 || s.buf.Len() != 0 {
+		t.Errorf("failed batch was kept: %d lines", s.lines)
+	}
+}
@@ -0,0 +1,122 @@
+package oura
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a LatestSet remembers the most recent value of every (user, field)
+// that has gone by, so that it can be scraped by Prometheus.  "Most
+// recent" means by document timestamp, not by arrival time, because
+// documents get re-fetched and arrive back-dated all the time.
+type LatestSet struct {
+	values map[latestKey]latestValue
+	Lock   sync.Mutex
+}
+
+type latestKey struct {
+	user  string
+	field string
+}
+
+type latestValue struct {
+	value float32
+	ts    time.Time
+}
+
+func MakeLatestSet() *LatestSet {
+	return &LatestSet{values: make(map[latestKey]latestValue)}
+}
+
+func (ls *LatestSet) Store(obs Observation) {
+	ls.Lock.Lock()
+	defer ls.Lock.Unlock()
+	k := latestKey{user: obs.Username, field: obs.Field}
+	if old, ok := ls.values[k]; ok && old.ts.After(obs.Timestamp) {
+		return
+	}
+	ls.values[k] = latestValue{value: obs.Value, ts: obs.Timestamp}
+}
+
+var promLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"",
+	"\n", "\\n")
+
+// WriteProm writes the whole set in the Prometheus text exposition
+// format.  There are two gauges for every series: the value, and the
+// timestamp of the document it came from, since the scrape time is
+// usually hours or days later than the observation.
+func (ls *LatestSet) WriteProm(w io.Writer) error {
+	ls.Lock.Lock()
+	keys := make([]latestKey, 0, len(ls.values))
+	vals := make(map[latestKey]latestValue, len(ls.values))
+	for k, v := range ls.values {
+		keys = append(keys, k)
+		vals[k] = v
+	}
+	ls.Lock.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].user != keys[j].user {
+			return keys[i].user < keys[j].user
+		}
+		return keys[i].field < keys[j].field
+	})
+	labels := func(k latestKey) string {
+		obs := Observation{Username: k.user, Field: k.field}
+		doc_type, metric := obs.SplitField()
+		return fmt.Sprintf("{user=\"%s\",doc_type=\"%s\",metric=\"%s\"}",
+			promLabelEscaper.Replace(k.user),
+			promLabelEscaper.Replace(doc_type),
+			promLabelEscaper.Replace(metric))
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("# HELP oura_observation Latest value of each Oura document metric.\n")
+	b.WriteString("# TYPE oura_observation gauge\n")
+	for _, k := range keys {
+		fmt.Fprintf(b, "oura_observation%s %g\n", labels(k), vals[k].value)
+	}
+	b.WriteString("# HELP oura_observation_timestamp_seconds Document timestamp of oura_observation.\n")
+	b.WriteString("# TYPE oura_observation_timestamp_seconds gauge\n")
+	for _, k := range keys {
+		fmt.Fprintf(b, "oura_observation_timestamp_seconds%s %d\n", labels(k),
+			vals[k].ts.Unix())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// promSink is the Sink that feeds a LatestSet.  There is nothing to
+// open or flush; it is a Sink so that it can be turned on and off in
+// the Sinks list like everything else.
+type promSink struct {
+	set  *LatestSet
+	open bool
+}
+
+func (s *promSink) Open() error {
+	s.open = true
+	return nil
+}
+
+func (s *promSink) Write(obs Observation) error {
+	s.set.Store(obs)
+	return nil
+}
+
+func (s *promSink) Flush() error {
+	return nil
+}
+
+func (s *promSink) Close() error {
+	s.open = false
+	return nil
+}
+
+func (s *promSink) Healthy() bool {
+	return s.open
+}
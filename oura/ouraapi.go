@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,7 +24,7 @@ func isSuccess(code int) bool {
 func validUrl(flagval *string) *url.URL {
 	v_url, err := url.Parse(*flagval)
 	if err != nil {
-		log.Fatalf("bogus url value: %s %v", flagval, err)
+		log.Fatalf("bogus url value: %s %v", *flagval, err)
 	}
 	return v_url
 }
@@ -41,38 +42,55 @@ func validResponseBody(res *http.Response) ([]byte, error) {
 	return body, nil
 }
 
-func process[T Doc](err error, doclist []T, name string,
-	sink chan<- Observation) int {
-	if err != nil {
-		log.Printf("document search failed: %s", err)
-	}
-	sent_count := 0
-	for _, doc := range doclist {
-		// I tried to use document timestamps to avoid saving duplicate
-		// observations, but it doesn't work without getting complicated,
-		// because documents arrive with back-dated timestamps.
-		sent_count += SendDoc(doc, name, sink)
+// statusError is the error for an unsuccessful response, so that the
+// caller can tell what kind it was
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("http response code was %v", e.code)
+}
+
+// isPermanent says whether err is a response that won't change if we
+// ask again, like a 404 for a document that was deleted.  Everything
+// else, including network errors, might work next time.  So might a
+// 401, once the token has been refreshed.
+func isPermanent(err error) bool {
+	var se statusError
+	if !errors.As(err, &se) {
+		return false
 	}
-	log.Printf("retrieved %d documents for %d observations",
-		len(doclist), sent_count)
-	return sent_count
+	return se.code >= 400 && se.code < 500 && !isRetryable(se.code) &&
+		se.code != http.StatusRequestTimeout &&
+		se.code != http.StatusUnauthorized
+}
+
+func isNotFound(err error) bool {
+	var se statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
 }
 
+// doGet fetches ouraurl and parses the JSON into pDest.  If private,
+// the response never gets logged, because it might contain things the
+// user typed.
 func doGet(cfg *ClientConfig, user string, ouraurl string,
-	pDest any) error {
+	pDest any, private bool) error {
 
-	client, cancel := cfg.OauthClient(user)
-	defer cancel()
-	log.Printf("doing GET %s", ouraurl)
-	res, err := client.Get(ouraurl)
+	res, err := retryGet(cfg, ouraurl, func() (*http.Client, func()) {
+		client, cancel := cfg.OauthClient(user)
+		return client, cancel
+	})
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 	if !isSuccess(res.StatusCode) {
 		body, _ := io.ReadAll(res.Body)
-		log.Printf("error %d response was %s", res.StatusCode, body)
-		return fmt.Errorf("http response code was %v", res.StatusCode)
+		if !private {
+			log.Printf("error %d response was %s", res.StatusCode, body)
+		}
+		return statusError{code: res.StatusCode}
 	}
 	buf, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -81,7 +99,9 @@ func doGet(cfg *ClientConfig, user string, ouraurl string,
 
 	err = json.Unmarshal(buf, pDest)
 	if err != nil {
-		log.Printf("unparseable response is: %s", buf)
+		if !private {
+			log.Printf("unparseable response is: %s", buf)
+		}
 		return err
 	}
 
@@ -90,58 +110,131 @@ func doGet(cfg *ClientConfig, user string, ouraurl string,
 
 func GetDocByID(cfg *ClientConfig, user string, endpoint string,
 	id string, pDest any) error {
+	return getDocByID(cfg, user, endpoint, id, pDest, false)
+}
+
+func getDocByID(cfg *ClientConfig, user string, endpoint string,
+	id string, pDest any, private bool) error {
 	ouraurl := cfg.OuraPath("/usercollection/" + endpoint + "/" + id)
-	return doGet(cfg, user, ouraurl.String(), pDest)
+	return doGet(cfg, user, ouraurl.String(), pDest, private)
 }
 
+// SearchAll refreshes the profile and searches every document type
+// for one user.  Each type starts from its own checkpoint, which only
+// moves forward when that search works, so a type that fails (for
+// example daily_resilience without the "stress" scope) gets caught up
+// later on its own.
 func SearchAll(cfg *ClientConfig, name string, sink chan<- Observation) {
-	// clunky, but I can't find a way to get around this with generics,
-	// and don't want to get reflect.* involved to save 10 lines.
-	dr := SearchResponse[dailyReadiness]{}
-	err := SearchDocs(cfg, name, "daily_readiness", &dr)
-	process(err, dr.Data, name, sink)
-	da := SearchResponse[dailyActivity]{}
-	err = SearchDocs(cfg, name, "daily_activity", &da)
-	process(err, da.Data, name, sink)
-	ds := SearchResponse[dailySleep]{}
-	err = SearchDocs(cfg, name, "daily_sleep", &ds)
-	process(err, ds.Data, name, sink)
-	dp := SearchResponse[sleepPeriod]{}
-	err = SearchDocs(cfg, name, "sleep", &dp)
-	process(err, dp.Data, name, sink)
-	hr := SearchResponse[heartrateInstant]{}
-	err = SearchDocs(cfg, name, "heartrate", &hr)
-	process(err, hr.Data, name, sink)
-	do := SearchResponse[dailySpo2]{}
-	err = SearchDocs(cfg, name, "daily_spo2", &do)
-	process(err, do.Data, name, sink)
-	de := SearchResponse[dailyResilience]{}
-	err = SearchDocs(cfg, name, "daily_resilience", &de)
-	process(err, de.Data, name, sink)
-	dt := SearchResponse[dailyStress]{}
-	err = SearchDocs(cfg, name, "daily_stress", &dt)
-	process(err, dt.Data, name, sink)
+	RefreshProfile(cfg, name, sink)
+	for _, dt := range cfg.enabledDocTypes() {
+		if !cfg.userWants(name, dt) {
+			continue
+		}
+		now := time.Now()
+		start, end := searchWindow(cfg, name, dt.endpoint)
+		_, err := searchChunks(cfg, name, dt, start, end, sink, false)
+		if err == nil {
+			cfg.UserTokens.SetCheckpoint(name, dt.endpoint, now)
+		} else if cfg.UserTokens.GetCheckpoint(name, dt.endpoint).IsZero() {
+			// this type has never worked.  remember where it should start
+			// from, or else it will follow LastUse forward.
+			cfg.UserTokens.SetCheckpoint(name, dt.endpoint,
+				start.Add(24*time.Hour))
+		}
+	}
 	cfg.UserTokens.Touch(name)
 }
 
-func SearchDocs(cfg *ClientConfig, name string, endpoint string,
-	pDest any) error {
-	ts := func(d time.Duration) string {
-		// go time.Format is odd
-		return time.Now().Add(d).Format("2006-01-02")
+// we never try to catch up more than this on a routine poll; older
+// than that is a job for Backfill.
+const maxCatchupDays = 30
+
+// searchWindow is the date range for a routine poll of one document
+// type.  It goes back one day before the last successful search of
+// that type, because documents keep getting updated for a while
+// after their day.
+func searchWindow(cfg *ClientConfig, name string,
+	endpoint string) (time.Time, time.Time) {
+	now := time.Now()
+	start := cfg.UserTokens.GetCheckpoint(name, endpoint)
+	if start.IsZero() {
+		if cfg.UserTokens.IsNew(name) {
+			// if we have never seen you before, start by searching backwards
+			// 7 days
+			start = now.Add(-24 * 6 * time.Hour)
+		} else {
+			// from before there were checkpoints
+			start = cfg.UserTokens.LastUse(name)
+		}
 	}
-	backfill_days := 1
-	if cfg.UserTokens.IsNew(name) {
-		// if we have never seen you before, start by searching backwards
-		// 7 days
-		backfill_days = 7
+	start = start.Add(-24 * time.Hour)
+	if oldest := now.Add(-24 * maxCatchupDays * time.Hour); start.Before(oldest) {
+		log.Printf("%s %s is more than %d days behind; use backfill",
+			name, endpoint, maxCatchupDays)
+		start = oldest
 	}
+	return start, now.Add(+24 * time.Hour)
+}
+
+func searchParams(datetimes bool, start time.Time,
+	end time.Time) url.Values {
 	params := url.Values{}
-	params.Add("start_date", ts(-24*time.Hour*time.Duration(backfill_days)))
-	params.Add("end_date", ts(+24*time.Hour))
-	ouraurl := cfg.OuraPath("/usercollection/" + endpoint)
-	ouraurl.RawQuery = params.Encode()
-	return doGet(cfg, name, ouraurl.String(), pDest)
+	if datetimes {
+		// heartrate takes datetimes instead of dates, and ignores
+		// start_date and end_date.  we still want whole days.
+		midnight := func(t time.Time) string {
+			y, m, d := t.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Format(time.RFC3339)
+		}
+		params.Add("start_datetime", midnight(start))
+		params.Add("end_datetime", midnight(end))
+	} else {
+		// go time.Format is odd
+		params.Add("start_date", start.Format("2006-01-02"))
+		params.Add("end_date", end.Format("2006-01-02"))
+	}
+	return params
+}
+
+// a search can be broken into pages, in which case the response has a
+// Next_token, and you get the next page by repeating the search with
+// next_token=whatever.  heartrate is the one that does this a lot,
+// because it is thousands of documents per day.  In case they ever
+// send us a Next_token that goes around in a loop, we will stop after
+// maxSearchPages.
+const maxSearchPages = 100
+
+// SearchPages searches for the documents between start and end,
+// following the Next_token through every page, and returns all of the
+// documents along with how many pages it took.  If a page fails, you
+// get the documents from the pages before it, and the error.
+func SearchPages[D Doc](cfg *ClientConfig, name string, dt *docType,
+	start time.Time, end time.Time) ([]D, int, error) {
+	docs := make([]D, 0)
+	endpoint := dt.endpoint
+	// the dates are the user's dates
+	loc := cfg.UserLocation(name)
+	params := searchParams(dt.datetimes, start.In(loc), end.In(loc))
+	pages := 0
+	for {
+		sr := SearchResponse[D]{}
+		ouraurl := cfg.OuraPath("/usercollection/" + endpoint)
+		ouraurl.RawQuery = params.Encode()
+		err := doGet(cfg, name, ouraurl.String(), &sr, dt.private)
+		if err != nil {
+			return docs, pages, err
+		}
+		pages += 1
+		docs = append(docs, sr.Data...)
+		if len(sr.Next_token) == 0 {
+			return docs, pages, nil
+		}
+		if pages >= maxSearchPages {
+			return docs, pages, fmt.Errorf("%s search still going after %d pages",
+				endpoint, pages)
+		}
+		params.Set("next_token", sr.Next_token)
+	}
 }
 
 func RandomString() string {
@@ -150,90 +243,183 @@ func RandomString() string {
 	return base64.URLEncoding.EncodeToString(nonce)
 }
 
+// an emitter collects the Observations from one document.  send
+// uses the document's own timestamp; sendTs is for the ones that are
+// part of a timeseries inside the document.
+type emitter struct {
+	cfg      *ClientConfig
+	doc      string // the docType prefix, even in a child
+	prefix   string
+	username string
+	ts       time.Time
+	sink     chan<- Observation
+	count    int
+}
+
+func (e *emitter) sendTs(k string, v float32, t time.Time) {
+	e.sink <- Observation{
+		Timestamp: t,
+		Username:  e.username,
+		Field:     fmt.Sprintf("%s.%s", e.prefix, k),
+		Value:     v,
+	}
+	e.count += 1
+}
+
+func (e *emitter) send(k string, v float32) {
+	e.sendTs(k, v, e.ts)
+}
+
+// enum looks up a string field of the document in the enum table
+func (e *emitter) enum(field string, value string) (float32, bool) {
+	return e.cfg.enumValue(e.doc+"."+field, value)
+}
+
+// child is an emitter for e.prefix.name, with its own timestamp and
+// count.  The caller has to add the count back.
+func (e *emitter) child(name string, ts time.Time) *emitter {
+	c := *e
+	if len(name) > 0 {
+		c.prefix = e.prefix + "." + name
+	}
+	c.ts = ts
+	c.count = 0
+	return &c
+}
+
+// SendDoc turns doc into Observations named prefix.whatever, and
+// sends them down the sink channel.  The docType decides what
+// extract function to use; if it is nil, we use sendFields.  A
+// document that only has a Day goes at the user's dayTime.
+func SendDoc[T Doc](cfg *ClientConfig, doc T, prefix string,
+	username string, extract func(T, *emitter),
+	sink chan<- Observation) int {
+	e := &emitter{
+		cfg:      cfg,
+		doc:      prefix,
+		prefix:   prefix,
+		username: username,
+		ts:       doc.GetTimestamp(),
+		sink:     sink,
+	}
+	if dd, ok := any(doc).(dayDoc); ok {
+		e.ts = cfg.dayTime(username, dd.GetDay())
+	}
+	if extract == nil {
+		sendFields(doc, e)
+	} else {
+		extract(doc, e)
+	}
+	return e.count
+}
+
 // oh god, generics and reflection, how did this get here, i am not
 // good at computer
 //
-// This somehow compiles.  It will take any Doc, find all of its
-// members that have type {int,float32,intervalMetric}, turn them into
-// Observations, and send those down the sink channel.
-
-func SendDoc[T Doc](doc T, username string, sink chan<- Observation) int {
-	sent_count := 0
-	send_ts := func(k string, v float32, t time.Time) {
-		sink <- Observation{
-			Timestamp: t,
-			Username:  username,
-			Field:     fmt.Sprintf("%s.%s", doc.GetMetricPrefix(), k),
-			Value:     v,
+// This somehow compiles.  It will take any Doc, find everything in it
+// that is a number (or a bool, or an intervalMetric), including inside
+// nested structs and maps, turn them into Observations, and send those
+// down the emitter.  A member Foo of a nested struct Bar comes out as
+// bar.foo.
+
+func sendFields[T Doc](doc T, e *emitter) {
+	sendValue(reflect.ValueOf(doc), "", e)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var intervalMetricType = reflect.TypeOf(intervalMetric{})
+
+func metricJoin(parent string, child string) string {
+	child = strings.ToLower(child)
+	// contributors are in several documents, and always spelled this way
+	if child == "contributors" {
+		child = "contrib"
+	}
+	if len(parent) == 0 {
+		return child
+	}
+	return parent + "." + child
+}
+
+func sendValue(v reflect.Value, metric_name string, e *emitter) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		e.send(metric_name, float32(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		e.send(metric_name, float32(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		e.send(metric_name, float32(v.Float()))
+	case reflect.Bool:
+		if v.Bool() {
+			e.send(metric_name, 1)
+		} else {
+			e.send(metric_name, 0)
 		}
-		sent_count += 1
-	}
-	send := func(k string, v float32) {
-		send_ts(k, v, doc.GetTimestamp())
-	}
-	s := reflect.ValueOf(&doc).Elem()
-	typeOfDoc := s.Type()
-	switch reflect.TypeOf(&doc) {
-	case reflect.TypeOf((*dailySpo2)(nil)):
-		ds := s.Interface().(dailySpo2)
-		send("daily_average", ds.Spo2_percentage.Average)
-	case reflect.TypeOf((*dailyResilience)(nil)):
-		// this one can almost get through the default handler, BUT the
-		// contributors map has float values this time.
-		dr := s.Interface().(dailyResilience)
-		send("level", float32(int(dr.Level)))
-		for k, v := range dr.Contributors {
-			send(fmt.Sprintf("contrib.%s", strings.ToLower(k)), v)
+	case reflect.Pointer, reflect.Interface:
+		// null in the document
+		if !v.IsNil() {
+			sendValue(v.Elem(), metric_name, e)
 		}
-	default:
-		// the default process is to find all members of doc that have type
-		// {int, float32, intervalMetric} and translate them into metrics
-		// of the same (lowercased) name.
-		for i := 0; i < s.NumField(); i++ {
-			f := s.Field(i)
-			metric_name := strings.ToLower(typeOfDoc.Field(i).Name)
-			// some types that we can always handle the same way
-			switch f.Type().Name() {
-			case "int":
-				send(metric_name, float32(f.Interface().(int)))
-			case "float32":
-				send(metric_name, f.Interface().(float32))
-			case "intervalMetric":
-				im := f.Interface().(intervalMetric)
-				for i, v := range im.Items {
-					t := im.Timestamp.Add(
-						time.Duration(float32(i)*im.Interval) * time.Second)
-					// these timeseries contain 'null' which go parses as 0; luckily it
-					// will never be a valid heart_rate or hrv or met
-					if v > 0.0 {
-						send_ts(metric_name, v, t)
-					}
-				}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if iter.Key().Kind() == reflect.String {
+				sendValue(iter.Value(), metricJoin(metric_name, iter.Key().String()), e)
 			}
-			// special oddball metrics in the default document types
-			switch metric_name {
-			case "contributors":
-				for k, v := range f.Interface().(map[string]int) {
-					send(fmt.Sprintf("contrib.%s", strings.ToLower(k)), float32(v))
+		}
+	case reflect.Struct:
+		switch v.Type() {
+		case timeType:
+			// timestamps are not observations
+		case intervalMetricType:
+			im := v.Interface().(intervalMetric)
+			for i, x := range im.Items {
+				t := im.Timestamp.Add(
+					time.Duration(float32(i)*im.Interval) * time.Second)
+				// these timeseries contain 'null' which go parses as 0; luckily it
+				// will never be a valid heart_rate or hrv or met
+				if x > 0.0 {
+					e.sendTs(metric_name, x, t)
 				}
-			case "movement_30_sec":
-				s := f.Interface().(string)
-				// GetTimestamp() returns bedtime_end on sleepPeriod, but we can find
-				// bedtime_start anyway
-				t0 := doc.GetTimestamp().Add(time.Duration(-30*len(s)) * time.Second)
-				for i := 0; i < len(s); i++ {
-					send_ts(metric_name, float32(s[i]-48),
-						t0.Add(time.Duration(30*i)*time.Second))
+			}
+		default:
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+				if !field.IsExported() {
+					continue
 				}
-			case "sleep_phase_5_min":
-				s := f.Interface().(string)
-				t0 := doc.GetTimestamp().Add(time.Duration(-300*len(s)) * time.Second)
-				for i := 0; i < len(s); i++ {
-					send_ts(metric_name, float32(s[i]-48),
-						t0.Add(time.Duration(300*i)*time.Second))
+				if field.Anonymous {
+					sendValue(v.Field(i), metric_name, e)
+				} else {
+					sendValue(v.Field(i), metricJoin(metric_name, field.Name), e)
 				}
 			}
 		}
+	case reflect.String:
+		// special oddball metrics in the default document types, and the
+		// ones in the enum table.  the rest of the strings are ids and
+		// dates and so on.
+		var step int
+		switch metric_name {
+		case "movement_30_sec":
+			step = 30
+		case "sleep_phase_5_min":
+			step = 300
+		default:
+			if x, ok := e.enum(metric_name, v.String()); ok {
+				e.send(metric_name, x)
+			}
+			return
+		}
+		s := v.String()
+		// GetTimestamp() returns bedtime_end on sleepPeriod, but we can find
+		// bedtime_start anyway
+		t0 := e.ts.Add(time.Duration(-step*len(s)) * time.Second)
+		for i := 0; i < len(s); i++ {
+			e.sendTs(metric_name, float32(s[i]-48),
+				t0.Add(time.Duration(step*i)*time.Second))
+		}
 	}
-	return sent_count
 }
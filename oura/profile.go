@@ -0,0 +1,69 @@
+package oura
+
+import (
+	"log"
+	"time"
+)
+
+// personal_info isn't a list of documents like the others, it's just
+// the one, and it only changes when the user edits their profile.  We
+// have been keeping it on the UserToken since they signed up.
+
+// the Checkpoints entry that records when we last sent the profile.
+// It can't be "personal_info", because the Checkpoints named after an
+// endpoint are where that endpoint's searches start from.
+const profileCheckpoint = "profile"
+
+// FetchPersonalInfo gets the user's personal_info, which is the only
+// way to find out their Oura user id.  It is private because it has
+// the email address in it.
+func FetchPersonalInfo(cfg *ClientConfig, name string) (PersonalInfo,
+	error) {
+	ouraurl := cfg.OuraPath("/usercollection/personal_info")
+	pi := PersonalInfo{}
+	err := doGet(cfg, name, ouraurl.String(), &pi, true)
+	return pi, err
+}
+
+// RefreshProfile fetches personal_info again and stores it.  If the
+// weight or height changed, or we have never sent them, it sends
+// profile.weight (kg), profile.height (m), and profile.bmi, unless the
+// user has ProfileOptOut.
+func RefreshProfile(cfg *ClientConfig, name string,
+	sink chan<- Observation) {
+	pi, err := FetchPersonalInfo(cfg, name)
+	if err != nil {
+		log.Printf("failed to refresh personal_info for %s: %s", name, err)
+		return
+	}
+	old := cfg.UserTokens.GetPersonalInfo(name)
+	if old != pi {
+		cfg.UserTokens.StorePersonalInfo(name, &pi)
+	}
+	if cfg.UserTokens.GetProfileOptOut(name) {
+		return
+	}
+	never_sent := cfg.UserTokens.GetCheckpoint(name, profileCheckpoint).IsZero()
+	if !never_sent && old.Weight == pi.Weight && old.Height == pi.Height {
+		return
+	}
+	e := &emitter{
+		cfg:      cfg,
+		doc:      "profile",
+		prefix:   "profile",
+		username: name,
+		ts:       time.Now(),
+		sink:     sink,
+	}
+	if pi.Weight > 0 {
+		e.send("weight", pi.Weight)
+	}
+	if pi.Height > 0 {
+		e.send("height", pi.Height)
+	}
+	if pi.Weight > 0 && pi.Height > 0 {
+		e.send("bmi", pi.Weight/(pi.Height*pi.Height))
+	}
+	log.Printf("sent %d profile observations for %s", e.count, name)
+	cfg.UserTokens.SetCheckpoint(name, profileCheckpoint, e.ts)
+}
@@ -0,0 +1,47 @@
+package oura
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRefreshProfile(t *testing.T) {
+	weight := 80.0
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/usercollection/personal_info") ||
+			len(r.URL.RawQuery) > 0 {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprintf(w, `{"id":"u1","age":40,"weight":%f,"height":2.0,`+
+			`"email":"bob@example.com"}`, weight)
+	})
+	cfg := testConfig(t, 0, api)
+
+	sink := make(chan Observation, 10)
+	RefreshProfile(cfg, "bob", sink)
+	got := collect(sink)
+	if len(got) != 3 || got["profile.bmi"][0].Value != 20 {
+		t.Errorf("first refresh sent %v", got)
+	}
+	// the marker must not be the personal_info checkpoint, which is
+	// where a search of that endpoint would start
+	if !cfg.UserTokens.GetCheckpoint("bob", "personal_info").IsZero() {
+		t.Errorf("RefreshProfile set the personal_info checkpoint")
+	}
+
+	sink = make(chan Observation, 10)
+	RefreshProfile(cfg, "bob", sink)
+	if got = collect(sink); len(got) != 0 {
+		t.Errorf("unchanged profile sent %v", got)
+	}
+
+	weight = 81
+	sink = make(chan Observation, 10)
+	RefreshProfile(cfg, "bob", sink)
+	if got = collect(sink); len(got["profile.weight"]) != 1 ||
+		got["profile.weight"][0].Value != 81 {
+		t.Errorf("changed profile sent %v", got)
+	}
+}
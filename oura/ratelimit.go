@@ -0,0 +1,151 @@
+package oura
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// a rateLimiter spaces out requests to Oura evenly, so that all the
+// users' polls put together don't go over RequestsPerMinute.  There
+// is one of these in the ClientConfig, shared by everything that
+// talks to the API.
+type rateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time // the earliest that the next request can go
+}
+
+func makeRateLimiter(per_minute int) *rateLimiter {
+	if per_minute <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(per_minute)}
+}
+
+// wait blocks until it is our turn.  A nil rateLimiter never blocks.
+func (rl *rateLimiter) wait() {
+	if rl == nil {
+		return
+	}
+	rl.lock.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	d := rl.next.Sub(now)
+	rl.next = rl.next.Add(rl.interval)
+	rl.lock.Unlock()
+	time.Sleep(d)
+}
+
+// holdoff makes everybody wait at least d, which is what Oura is
+// asking for when it sends a 429 with Retry-After.
+func (rl *rateLimiter) holdoff(d time.Duration) {
+	if rl == nil {
+		return
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if t := time.Now().Add(d); t.After(rl.next) {
+		rl.next = t
+	}
+}
+
+func isRetryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Retry-After is either a number of seconds or an HTTP date.  Zero
+// means there wasn't one we could read.
+func retryAfter(res *http.Response) time.Duration {
+	h := res.Header.Get("Retry-After")
+	if len(h) == 0 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+const retryBaseDelay = 2 * time.Second
+const defaultRetryMaxSeconds = 300
+
+// retryBackoff doubles with every attempt, plus up to as much again
+// of jitter, but never goes over max_wait.  It doesn't shift far
+// enough to overflow, which a big MaxRetries would otherwise do.
+func retryBackoff(attempt int, max_wait time.Duration) time.Duration {
+	backoff := max_wait
+	if attempt < 30 && retryBaseDelay<<attempt < max_wait {
+		backoff = retryBaseDelay << attempt
+	}
+	backoff += time.Duration(rand.Int63n(int64(backoff)))
+	if backoff > max_wait {
+		backoff = max_wait
+	}
+	return backoff
+}
+
+// retryGet does a GET through the rate limiter, and retries network
+// errors, 429s, and 5xx with jittered exponential backoff, or as long
+// as Retry-After says if that is longer.  A token that fails to
+// refresh (invalid_grant, say) is not retried, since it would only
+// fail the same way after a long wait.  A new client comes from
+// newClient for every attempt, because an oauth client's context has
+// a short timeout that could run out while we sleep.
+func retryGet(cfg *ClientConfig, ouraurl string,
+	newClient func() (*http.Client, func())) (*http.Response, error) {
+	max_wait := time.Duration(cfg.RetryMaxSeconds) * time.Second
+	if max_wait <= 0 {
+		max_wait = defaultRetryMaxSeconds * time.Second
+	}
+	var rerr *oauth2.RetrieveError
+	for attempt := 0; ; attempt++ {
+		cfg.limiter.wait()
+		client, cancel := newClient()
+		if client == nil {
+			return nil, fmt.Errorf("no oauth client")
+		}
+		log.Printf("doing GET %s", ouraurl)
+		res, err := client.Get(ouraurl)
+		cancel()
+		var wait time.Duration
+		if err == nil {
+			if !isRetryable(res.StatusCode) {
+				return res, nil
+			}
+			wait = retryAfter(res)
+			if res.StatusCode == http.StatusTooManyRequests {
+				// everyone else should back off too
+				cfg.limiter.holdoff(wait)
+			}
+			if attempt >= cfg.MaxRetries || wait > max_wait {
+				return res, nil
+			}
+			res.Body.Close()
+			err = fmt.Errorf("http response code was %v", res.StatusCode)
+		} else if errors.As(err, &rerr) || attempt >= cfg.MaxRetries {
+			return nil, err
+		}
+		if backoff := retryBackoff(attempt, max_wait); backoff > wait {
+			wait = backoff
+		}
+		if wait > max_wait {
+			wait = max_wait
+		}
+		log.Printf("GET failed (%s), try %d of %d in %s", err, attempt+1,
+			cfg.MaxRetries, wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
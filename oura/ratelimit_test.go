@@ -0,0 +1,57 @@
+package oura
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	max_wait := 300 * time.Second
+	prev := time.Duration(0)
+	for attempt := 0; attempt < 100; attempt++ {
+		b := retryBackoff(attempt, max_wait)
+		if b <= 0 || b > max_wait {
+			t.Fatalf("attempt %d: backoff %s", attempt, b)
+		}
+		if attempt >= 8 && b != max_wait {
+			t.Errorf("attempt %d: backoff %s, want %s", attempt, b, max_wait)
+		}
+		if b < prev/2 {
+			t.Errorf("attempt %d: backoff %s went down from %s", attempt, b,
+				prev)
+		}
+		prev = b
+	}
+	if b := retryBackoff(0, max_wait); b < retryBaseDelay ||
+		b >= 2*retryBaseDelay {
+		t.Errorf("first backoff %s", b)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRetryGetTokenError(t *testing.T) {
+	cfg := testConfig(t, 0, nil)
+	cfg.MaxRetries = 5
+	tries := 0
+	client := &http.Client{Transport: roundTripFunc(
+		func(r *http.Request) (*http.Response, error) {
+			tries += 1
+			return nil, &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: 400},
+				Body:     []byte(`{"error":"invalid_grant"}`),
+			}
+		})}
+	_, err := retryGet(cfg, "http://127.0.0.1/v2/usercollection/sleep",
+		func() (*http.Client, func()) { return client, func() {} })
+	if err == nil || tries != 1 {
+		t.Errorf("%d tries, err %v; want one try and an error", tries, err)
+	}
+}
@@ -0,0 +1,297 @@
+package oura
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// a docType is everything we need to know about one kind of Oura
+// document.  To support a new kind, write a struct for it in types.go
+// and add a line to docTypes; the polling, webhooks, subscriptions,
+// and backfills all work from this list.
+type docType struct {
+	endpoint  string // route under /usercollection, also the webhook data_type
+	prefix    string // first component of every metric name
+	webhook   bool   // oura will send notifications for this type
+	enabled   bool   // default; the DocTypes config setting overrides it
+	datetimes bool   // searches take start_datetime instead of start_date
+	chunkDays int    // widest search range to ask for at once
+	private   bool   // has free text in it, so never log the responses
+	optIn     bool   // only for users with it in their DocTypeOptIn
+	refetch   bool   // webhooks search the days around the document
+	localTime bool   // GetTimestamp has the user's UTC offset on it
+	batch     any    // func([]D, *emitter), see withBatch
+	search    func(cfg *ClientConfig, dt *docType, name string,
+		start time.Time, end time.Time, sink chan<- Observation) (int, error)
+	// when is roughly when the document changed, or zero if unknown
+	fetch func(cfg *ClientConfig, dt *docType, name string, id string,
+		when time.Time, sink chan<- Observation) (int, error)
+}
+
+// register makes a docType for document struct D.  extract turns one
+// document into Observations; if it is nil, sendFields does it by
+// reflection.
+func register[D Doc](endpoint string, prefix string, webhook bool,
+	extract func(D, *emitter)) docType {
+	dt := docType{
+		endpoint:  endpoint,
+		prefix:    prefix,
+		webhook:   webhook,
+		enabled:   true,
+		chunkDays: 30,
+	}
+	// sendDocs is what we do with the documents from a search
+	sendDocs := func(cfg *ClientConfig, dt *docType, name string, docs []D,
+		pages int, err error, sink chan<- Observation) int {
+		if dt.localTime && len(docs) > 0 {
+			cfg.learnOffset(name, docs[len(docs)-1].GetTimestamp())
+		}
+		sent_count := 0
+		for _, doc := range docs {
+			// I tried to use document timestamps to avoid saving duplicate
+			// observations, but it doesn't work without getting complicated,
+			// because documents arrive with back-dated timestamps.
+			sent_count += SendDoc(cfg, doc, dt.prefix, name, extract, sink)
+		}
+		if batch, ok := dt.batch.(func([]D, *emitter)); ok && len(docs) > 0 {
+			if err != nil {
+				// a day's worth of summary from part of the day would
+				// overwrite the good one, so send nothing until the search
+				// works
+				log.Printf("skipping %s summary of an incomplete search",
+					dt.endpoint)
+			} else {
+				e := &emitter{cfg: cfg, doc: dt.prefix, prefix: dt.prefix,
+					username: name, sink: sink}
+				batch(docs, e)
+				sent_count += e.count
+			}
+		}
+		log.Printf("retrieved %d %s documents in %d pages for %d observations",
+			len(docs), dt.endpoint, pages, sent_count)
+		return sent_count
+	}
+	dt.search = func(cfg *ClientConfig, dt *docType, name string,
+		start time.Time, end time.Time, sink chan<- Observation) (int, error) {
+		docs, pages, err := SearchPages[D](cfg, name, dt, start, end)
+		if err != nil {
+			log.Printf("document search failed: %s", err)
+		}
+		return sendDocs(cfg, dt, name, docs, pages, err, sink), err
+	}
+	dt.fetch = func(cfg *ClientConfig, dt *docType, name string, id string,
+		when time.Time, sink chan<- Observation) (int, error) {
+		if dt.refetch && !when.IsZero() {
+			// the batch needs to see the rest of the day, so search the
+			// days around when the document changed.  That almost always
+			// finds it, and saves fetching it first to find out its day.
+			start, end := refetchWindow(cfg, name, when)
+			docs, pages, err := SearchPages[D](cfg, name, dt, start, end)
+			if err != nil {
+				return 0, err
+			}
+			if hasID(docs, id) {
+				return sendDocs(cfg, dt, name, docs, pages, nil, sink), nil
+			}
+		}
+		var doc D
+		err := getDocByID(cfg, name, dt.endpoint, id, &doc, dt.private)
+		if err != nil {
+			return 0, err
+		}
+		if dt.refetch {
+			// an old document that changed, so search around its own day
+			start, end := refetchWindow(cfg, name, doc.GetTimestamp())
+			return dt.search(cfg, dt, name, start, end, sink)
+		}
+		if dt.localTime {
+			cfg.learnOffset(name, doc.GetTimestamp())
+		}
+		return SendDoc(cfg, doc, dt.prefix, name, extract, sink), nil
+	}
+	return dt
+}
+
+func (dt docType) withDatetimes() docType {
+	dt.datetimes = true
+	return dt
+}
+
+func (dt docType) withChunkDays(days int) docType {
+	dt.chunkDays = days
+	return dt
+}
+
+func (dt docType) withPrivate() docType {
+	dt.private = true
+	return dt
+}
+
+// we can learn the user's timezone from this type; see timezone.go
+func (dt docType) withLocalTime() docType {
+	dt.localTime = true
+	return dt
+}
+
+// skipDoc is the extract function for types where the batch step
+// does all the work
+func skipDoc[D Doc](doc D, e *emitter) {}
+
+// withBatch adds a step that sees all of the documents from one
+// search at once, for observations that are worked out from more than
+// one document.  There is no document timestamp, so it has to use
+// sendTs or child.  Webhooks only fetch one document, so they skip
+// this, unless the type also has withRefetch.  It is also skipped
+// when the search fails partway, since it would be working from only
+// some of the documents.
+func withBatch[D Doc](dt docType, batch func([]D, *emitter)) docType {
+	dt.batch = batch
+	return dt
+}
+
+// a webhook for this type searches the days around the document,
+// instead of fetching just the one, so that the batch step sees it
+func (dt docType) withRefetch() docType {
+	dt.refetch = true
+	return dt
+}
+
+// refetchWindow is the day before t through the day after, in the
+// user's days, which is how sleepPeriods and dayTime see them
+func refetchWindow(cfg *ClientConfig, name string,
+	t time.Time) (time.Time, time.Time) {
+	loc := cfg.UserLocation(name)
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d-1, 0, 0, 0, 0, loc),
+		time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
+
+// an idDoc can tell us its id, which is how a refetch knows the search
+// found the document it was looking for
+type idDoc interface {
+	GetID() string
+}
+
+func hasID[D Doc](docs []D, id string) bool {
+	for _, doc := range docs {
+		if d, ok := any(doc).(idDoc); ok && d.GetID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// only fetched for the users that ask for it; see userWants
+func (dt docType) optInOnly() docType {
+	dt.optIn = true
+	return dt
+}
+
+// off unless the DocTypes setting turns it on
+func (dt docType) disabled() docType {
+	dt.enabled = false
+	return dt
+}
+
+// the document types we know about, in the order that we poll them
+var docTypes = []docType{
+	register[dailyReadiness]("daily_readiness", "readiness", true, nil).
+		withLocalTime(),
+	register[dailyActivity]("daily_activity", "activity", true,
+		dailyActivity.extract).withLocalTime(),
+	register[dailySleep]("daily_sleep", "sleep", true, nil).withLocalTime(),
+	// danger of overwriting metrics from dailySleep document, but they
+	// don't appear to contain any of the same keys??  There can be more
+	// than one of these per day; see sleepPeriods.
+	withBatch(register[sleepPeriod]("sleep", "sleep", true,
+		skipDoc[sleepPeriod]), sleepPeriods).withRefetch().withLocalTime(),
+	// heartrate is thousands of documents a day, so a wide search is a
+	// huge number of pages
+	withBatch(register[heartrateInstant]("heartrate", "hr", false, nil).
+		withDatetimes().withChunkDays(7), heartrateDaily),
+	register[dailySpo2]("daily_spo2", "spo2", true, dailySpo2.extract),
+	// there is no such thing as daily_resilience subscription as of
+	// 2024-08-11.
+	register[dailyResilience]("daily_resilience", "resilience", false,
+		dailyResilience.extract),
+	register[dailyStress]("daily_stress", "stress", true, nil),
+	// workouts have the user's Label in them, and rest mode episodes
+	// have tags, so don't log those responses
+	register[workout]("workout", "workout", true, workout.extract).
+		withPrivate(),
+	register[session]("session", "session", true, session.extract),
+	register[sleepTime]("sleep_time", "sleep_time", true, sleepTime.extract),
+	register[restModePeriod]("rest_mode_period", "rest_mode", true,
+		restModePeriod.extract).withPrivate(),
+	// no webhooks for these two, but they only change once a day
+	register[vo2Max]("vO2_max", "vo2max", false, vo2Max.extract),
+	register[cardiovascularAge]("daily_cardiovascular_age", "cardio_age",
+		false, cardiovascularAge.extract),
+	// tags need the "tag" scope, and only record what is allow-listed
+	// (see tags.go)
+	register[tag]("tag", "tag", true, tag.extract).
+		withPrivate().disabled(),
+	register[enhancedTag]("enhanced_tag", "tag", true, enhancedTag.extract).
+		withPrivate().disabled(),
+	// too personal to collect unless the user asks for it
+	register[cyclePhase]("daily_cycle_phases", "cycle", true,
+		cyclePhase.extract).optInOnly(),
+	register[ringConfiguration]("ring_configuration", "ring", true,
+		ringConfiguration.extract),
+}
+
+func findDocType(endpoint string) *docType {
+	for i := range docTypes {
+		if docTypes[i].endpoint == endpoint {
+			return &docTypes[i]
+		}
+	}
+	return nil
+}
+
+// docTypeEnabled says whether we should poll and subscribe to dt.
+// The DocTypes map in the config file can turn any of them on or off,
+// by endpoint name.
+func (cfg *ClientConfig) docTypeEnabled(dt *docType) bool {
+	if on, ok := cfg.DocTypes[dt.endpoint]; ok {
+		return on
+	}
+	return dt.enabled
+}
+
+func (cfg *ClientConfig) enabledDocTypes() []*docType {
+	dts := make([]*docType, 0, len(docTypes))
+	for i := range docTypes {
+		if cfg.docTypeEnabled(&docTypes[i]) {
+			dts = append(dts, &docTypes[i])
+		}
+	}
+	return dts
+}
+
+// userWants says whether dt should be fetched for this user.  That is
+// everything that is enabled, except for the optIn types, which the
+// user has to list in DocTypeOptIn.
+func (cfg *ClientConfig) userWants(name string, dt *docType) bool {
+	if !dt.optIn {
+		return true
+	}
+	for _, e := range cfg.UserTokens.GetDocTypeOptIn(name) {
+		if e == dt.endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDocTypes complains about any name in the DocTypes setting that
+// isn't a document type, since that is most likely a typo.
+func (cfg *ClientConfig) checkDocTypes() error {
+	for k := range cfg.DocTypes {
+		if findDocType(k) == nil {
+			return fmt.Errorf("unknown document type %s in DocTypes", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package oura
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchSkippedOnFailedSearch(t *testing.T) {
+	page2_status := http.StatusInternalServerError
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/usercollection/heartrate") {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.FormValue("next_token") == "" {
+			fmt.Fprint(w, `{"data":[
+				{"bpm":60,"source":"awake","timestamp":"2024-08-11T10:00:00+00:00"},
+				{"bpm":150,"source":"workout","timestamp":"2024-08-11T10:01:00+00:00"}
+			],"next_token":"p2"}`)
+			return
+		}
+		w.WriteHeader(page2_status)
+		fmt.Fprint(w, `{"data":[
+			{"bpm":40,"source":"sleep","timestamp":"2024-08-11T23:00:00+00:00"}
+		],"next_token":null}`)
+	})
+	cfg := testConfig(t, 0, api)
+	dt := findDocType("heartrate")
+	start := time.Date(2024, 8, 11, 0, 0, 0, 0, time.UTC)
+
+	sink := make(chan Observation, 100)
+	_, err := dt.search(cfg, dt, "bob", start, start.Add(24*time.Hour), sink)
+	got := collect(sink)
+	if err == nil {
+		t.Fatalf("search succeeded with a failed page")
+	}
+	if len(got["hr.bpm"]) != 2 {
+		t.Errorf("got %d hr.bpm from the good page, want 2",
+			len(got["hr.bpm"]))
+	}
+	for k := range got {
+		if strings.HasPrefix(k, "hr.daily.") {
+			t.Errorf("sent %s from an incomplete search", k)
+		}
+	}
+
+	page2_status = http.StatusOK
+	sink = make(chan Observation, 100)
+	_, err = dt.search(cfg, dt, "bob", start, start.Add(24*time.Hour), sink)
+	got = collect(sink)
+	if err != nil {
+		t.Fatalf("search: %s", err)
+	}
+	if m := got["hr.daily.min"]; len(m) != 1 || m[0].Value != 40 {
+		t.Errorf("hr.daily.min is %v, want 40", m)
+	}
+}
+
+func TestRefetchWindowUserDays(t *testing.T) {
+	cfg := testConfig(t, -7*3600, nil)
+	loc := time.FixedZone("", -7*3600)
+	// still the evening of the 10th for bob
+	when := time.Date(2024, 8, 11, 5, 0, 0, 0, time.UTC)
+	start, end := refetchWindow(cfg, "bob", when)
+	want_start := time.Date(2024, 8, 9, 0, 0, 0, 0, loc)
+	want_end := time.Date(2024, 8, 11, 0, 0, 0, 0, loc)
+	if !start.Equal(want_start) || !end.Equal(want_end) {
+		t.Errorf("window is %s to %s, want %s to %s", start, end,
+			want_start, want_end)
+	}
+	params := searchParams(false, start.In(loc), end.In(loc))
+	if params.Get("start_date") != "2024-08-09" ||
+		params.Get("end_date") != "2024-08-11" {
+		t.Errorf("search params are %s", params.Encode())
+	}
+}
@@ -0,0 +1,201 @@
+package oura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// remoteWriteSink sends observations with the Prometheus remote_write
+// protocol, which is the only way to get them into a Prometheus-ish
+// database (Mimir, VictoriaMetrics, Thanos receive) with their real,
+// back-dated timestamps.  The series are named and labeled the same
+// way as on /metrics.
+//
+// The wire format is a snappy-compressed protobuf WriteRequest.  It is
+// simple enough that it is encoded by hand here instead of pulling in
+// the prometheus and gogo/protobuf modules.
+type remoteWriteSink struct {
+	conf    SinkConfig
+	client  *http.Client
+	series  map[latestKey][]rwSample
+	samples int
+}
+
+type rwSample struct {
+	value float64
+	ts    int64 // milliseconds
+}
+
+const defaultRemoteWriteBatch = 2000
+const remoteWriteRetries = 5
+
+func (s *remoteWriteSink) batchSize() int {
+	if s.conf.BatchSize > 0 {
+		return s.conf.BatchSize
+	}
+	return defaultRemoteWriteBatch
+}
+
+func (s *remoteWriteSink) Open() error {
+	s.client = &http.Client{Timeout: 30 * time.Second}
+	s.series = make(map[latestKey][]rwSample)
+	s.samples = 0
+	return nil
+}
+
+func (s *remoteWriteSink) Write(obs Observation) error {
+	k := latestKey{user: obs.Username, field: obs.Field}
+	s.series[k] = append(s.series[k], rwSample{
+		value: float64(obs.Value),
+		ts:    obs.Timestamp.UnixMilli(),
+	})
+	if s.samples += 1; s.samples >= s.batchSize() {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *remoteWriteSink) Flush() error {
+	if s.samples == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, s.encode())
+	n := s.samples
+	s.series = make(map[latestKey][]rwSample)
+	s.samples = 0
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			// the receiver didn't like something about this batch (out of
+			// order samples, usually).  sending it again won't help, and
+			// there is nothing wrong with the connection, so drop it.
+			log.Printf("remote_write rejected %d samples: %s", n, err)
+			return nil
+		}
+		if attempt >= remoteWriteRetries {
+			return fmt.Errorf("gave up on %d samples after %d tries: %s",
+				n, attempt, err)
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("remote_write failed (%s), retrying in %s", err, wait)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// post returns whether the error, if there was one, is worth retrying.
+func (s *remoteWriteSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if len(s.conf.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.conf.Token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	if isSuccess(res.StatusCode) {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	err = fmt.Errorf("http status was %s: %s", res.Status,
+		bytes.TrimSpace(msg))
+	return res.StatusCode == 429 || res.StatusCode >= 500, err
+}
+
+// protobuf encoding of prometheus.WriteRequest, from remote.proto and
+// types.proto:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+
+func pbTag(b []byte, field int, wiretype int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wiretype))
+}
+
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = pbTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (s *remoteWriteSink) encode() []byte {
+	keys := make([]latestKey, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].user != keys[j].user {
+			return keys[i].user < keys[j].user
+		}
+		return keys[i].field < keys[j].field
+	})
+
+	var wr, ts, lbl, smp []byte
+	for _, k := range keys {
+		obs := Observation{Username: k.user, Field: k.field}
+		doc_type, metric := obs.SplitField()
+		ts = ts[:0]
+		// labels have to be sorted by name
+		for _, l := range [][2]string{
+			{"__name__", "oura_observation"},
+			{"doc_type", doc_type},
+			{"metric", metric},
+			{"user", k.user},
+		} {
+			lbl = pbBytes(lbl[:0], 1, []byte(l[0]))
+			lbl = pbBytes(lbl, 2, []byte(l[1]))
+			ts = pbBytes(ts, 1, lbl)
+		}
+		// and samples have to be in time order within a series
+		samples := s.series[k]
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].ts < samples[j].ts
+		})
+		for _, sa := range samples {
+			smp = pbTag(smp[:0], 1, 1)
+			smp = binary.LittleEndian.AppendUint64(smp, math.Float64bits(sa.value))
+			smp = pbTag(smp, 2, 0)
+			smp = binary.AppendUvarint(smp, uint64(sa.ts))
+			ts = pbBytes(ts, 2, smp)
+		}
+		wr = pbBytes(wr, 1, ts)
+	}
+	return wr
+}
+
+func (s *remoteWriteSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.Flush()
+	s.client = nil
+	return err
+}
+
+func (s *remoteWriteSink) Healthy() bool {
+	return s.client != nil
+}
@@ -0,0 +1,260 @@
+package oura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// pbField is one field of a decoded protobuf message
+type pbField struct {
+	num      int
+	wiretype int
+	varint   uint64
+	fixed64  uint64
+	bytes    []byte
+}
+
+// pbDecode splits a protobuf message into its fields, which is enough
+// to check what encode wrote without a generated WriteRequest type.
+func pbDecode(t *testing.T, b []byte) []pbField {
+	t.Helper()
+	fields := make([]pbField, 0)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad tag varint")
+		}
+		b = b[n:]
+		f := pbField{num: int(tag >> 3), wiretype: int(tag & 7)}
+		switch f.wiretype {
+		case 0:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("bad varint in field %d", f.num)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				t.Fatalf("short fixed64 in field %d", f.num)
+			}
+			f.fixed64 = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatalf("bad length in field %d", f.num)
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d in field %d", f.wiretype, f.num)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func TestRemoteWriteEncode(t *testing.T) {
+	s := &remoteWriteSink{}
+	s.Open()
+	t0 := time.UnixMilli(1719288000123)
+	// out of order on purpose, and two series, also out of order
+	s.Write(Observation{Timestamp: t0.Add(time.Minute), Username: "bob",
+		Field: "sleep.score", Value: 81})
+	s.Write(Observation{Timestamp: t0, Username: "bob",
+		Field: "sleep.score", Value: 80})
+	s.Write(Observation{Timestamp: t0, Username: "al",
+		Field: "readiness.contrib.hrv_balance", Value: 1.5})
+
+	wr := pbDecode(t, s.encode())
+	if len(wr) != 2 {
+		t.Fatalf("%d timeseries, want 2", len(wr))
+	}
+	want := []struct {
+		labels [][2]string
+		values []float64
+		stamps []uint64
+	}{
+		{
+			labels: [][2]string{
+				{"__name__", "oura_observation"},
+				{"doc_type", "readiness"},
+				{"metric", "contrib.hrv_balance"},
+				{"user", "al"},
+			},
+			values: []float64{1.5},
+			stamps: []uint64{1719288000123},
+		},
+		{
+			labels: [][2]string{
+				{"__name__", "oura_observation"},
+				{"doc_type", "sleep"},
+				{"metric", "score"},
+				{"user", "bob"},
+			},
+			values: []float64{80, 81},
+			stamps: []uint64{1719288000123, 1719288060123},
+		},
+	}
+	for i, w := range want {
+		if wr[i].num != 1 || wr[i].wiretype != 2 {
+			t.Fatalf("timeseries %d is field %d type %d", i, wr[i].num,
+				wr[i].wiretype)
+		}
+		ts := pbDecode(t, wr[i].bytes)
+		if len(ts) != len(w.labels)+len(w.values) {
+			t.Fatalf("timeseries %d has %d fields", i, len(ts))
+		}
+		// labels first, in name order
+		for j, l := range w.labels {
+			if ts[j].num != 1 {
+				t.Fatalf("timeseries %d field %d is %d, want a label", i, j,
+					ts[j].num)
+			}
+			lbl := pbDecode(t, ts[j].bytes)
+			if len(lbl) != 2 || lbl[0].num != 1 || lbl[1].num != 2 ||
+				string(lbl[0].bytes) != l[0] || string(lbl[1].bytes) != l[1] {
+				t.Errorf("timeseries %d label %d is %q=%q, want %q=%q", i, j,
+					lbl[0].bytes, lbl[1].bytes, l[0], l[1])
+			}
+		}
+		// then samples, in time order
+		for j := range w.values {
+			f := ts[len(w.labels)+j]
+			if f.num != 2 {
+				t.Fatalf("timeseries %d field is %d, want a sample", i, f.num)
+			}
+			smp := pbDecode(t, f.bytes)
+			if len(smp) != 2 || smp[0].num != 1 || smp[0].wiretype != 1 ||
+				smp[1].num != 2 || smp[1].wiretype != 0 {
+				t.Fatalf("timeseries %d sample %d is malformed: %+v", i, j, smp)
+			}
+			if v := math.Float64frombits(smp[0].fixed64); v != w.values[j] {
+				t.Errorf("timeseries %d sample %d value %f, want %f", i, j, v,
+					w.values[j])
+			}
+			if smp[1].varint != w.stamps[j] {
+				t.Errorf("timeseries %d sample %d timestamp %d, want %d", i, j,
+					smp[1].varint, w.stamps[j])
+			}
+		}
+	}
+}
+
+func TestRemoteWriteSampleBytes(t *testing.T) {
+	// one sample, to check the exact bytes: a little-endian double and a
+	// varint timestamp
+	s := &remoteWriteSink{}
+	s.Open()
+	s.Write(Observation{Timestamp: time.UnixMilli(1000), Username: "u",
+		Field: "d.m", Value: 1.5})
+	wr := pbDecode(t, s.encode())
+	ts := pbDecode(t, wr[0].bytes)
+	got := ts[len(ts)-1].bytes
+	want := []byte{
+		0x09, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f, // field 1, fixed64, 1.5
+		0x10, 0xe8, 0x07, // field 2, varint, 1000
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("sample bytes are % x, want % x", got, want)
+	}
+}
+
+func TestRemoteWriteFlush(t *testing.T) {
+	var got []byte
+	var hdr http.Header
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			hdr = r.Header
+			body, _ := io.ReadAll(r.Body)
+			var err error
+			if got, err = snappy.Decode(nil, body); err != nil {
+				t.Errorf("body is not snappy: %s", err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer srv.Close()
+
+	s := &remoteWriteSink{conf: SinkConfig{URL: srv.URL, Token: "sekrit"}}
+	s.Open()
+	s.Write(Observation{Timestamp: time.UnixMilli(1000), Username: "u",
+		Field: "d.m", Value: 2})
+	want := s.encode()
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("server got % x, want % x", got, want)
+	}
+	for k, v := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer sekrit",
+	} {
+		if hdr.Get(k) != v {
+			t.Errorf("header %s is %q, want %q", k, hdr.Get(k), v)
+		}
+	}
+	if s.samples != 0 || len(s.series) != 0 {
+		t.Errorf("Flush left %d samples behind", s.samples)
+	}
+}
+
+func TestRemoteWritePostRetry(t *testing.T) {
+	status := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+	defer srv.Close()
+
+	s := &remoteWriteSink{conf: SinkConfig{URL: srv.URL}}
+	s.Open()
+	for _, c := range []struct {
+		status int
+		retry  bool
+		fail   bool
+	}{
+		{http.StatusNoContent, false, false},
+		{http.StatusOK, false, false},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusInternalServerError, true, true},
+		{http.StatusServiceUnavailable, true, true},
+		{http.StatusBadRequest, false, true},
+		{http.StatusUnauthorized, false, true},
+		{http.StatusConflict, false, true},
+	} {
+		status = c.status
+		retry, err := s.post([]byte{})
+		if retry != c.retry || (err != nil) != c.fail {
+			t.Errorf("status %d: retry %v err %v, want retry %v fail %v",
+				c.status, retry, err, c.retry, c.fail)
+		}
+	}
+
+	// a 4xx batch is dropped, and the sink stays up
+	status = http.StatusBadRequest
+	s.Write(Observation{Timestamp: time.UnixMilli(1000), Username: "u",
+		Field: "d.m", Value: 2})
+	if err := s.Flush(); err != nil {
+		t.Errorf("Flush of a rejected batch returned %s", err)
+	}
+	if s.samples != 0 {
+		t.Errorf("rejected batch was kept")
+	}
+
+	// a network error is worth retrying
+	srv.Close()
+	if retry, err := s.post([]byte{}); !retry || err == nil {
+		t.Errorf("closed server: retry %v err %v", retry, err)
+	}
+}
@@ -0,0 +1,165 @@
+package oura
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Sink is somewhere that Observations go to be stored.  Each Sink
+// is driven by exactly one goroutine (see StoreObservations), so
+// implementations don't need to do their own locking.  Write is
+// allowed to buffer; anything buffered must be pushed out by Flush.
+// After Close, the sink should be reopenable with Open.
+
+type Sink interface {
+	Open() error
+	Write(obs Observation) error
+	Flush() error
+	Close() error
+	Healthy() bool
+}
+
+// a SinkConfig is one entry in the Sinks list in the config file.
+// Which members matter depends on Type.
+type SinkConfig struct {
+	// "file", "graphite", "influxdb", "prometheus", or "remote_write"
+	Type         string
+	Path         string // file name, for "file"
+	Format       string // "graphite" (default) or "influx", for "file"
+	Server       string // host:port, for "graphite"
+	URL          string // server URL, for "influxdb" and "remote_write"
+	Org          string // for "influxdb"
+	Bucket       string // for "influxdb"
+	Token        string // API token, for "influxdb" and "remote_write"
+	Prefix       string // prepended to every metric name (graphite format)
+	Measurement  string // measurement name (influx format), default "oura"
+	BufferSize   int    // observations that can wait before the sender does
+	BatchSize    int    // observations per flush (and per remote_write request)
+	RetrySeconds int    // how long to wait to reopen after a write error
+	// where observations wait while the sink is down, if anywhere
+	SpoolFile     string
+	SpoolMaxBytes int64 // default 64MB, after which we drop observations
+}
+
+// all sinks get closed and reopened on this interval, which is how
+// we find out that a dead graphite server has come back, and how log
+// rotation works.
+const reconnectInterval = 15 * time.Minute
+
+const defaultSinkBuffer = 1000
+const defaultSinkBatch = 500
+
+func (sc SinkConfig) String() string {
+	switch sc.Type {
+	case "file":
+		return fmt.Sprintf("file:%s", sc.Path)
+	case "graphite":
+		return fmt.Sprintf("graphite:%s", sc.Server)
+	case "influxdb":
+		return fmt.Sprintf("influxdb:%s/%s", sc.URL, sc.Bucket)
+	case "remote_write":
+		return fmt.Sprintf("remote_write:%s", sc.URL)
+	}
+	return sc.Type
+}
+
+// after a write error, local files are worth reopening right away.
+// network servers are probably down, and we don't want to hang on
+// TCP connect attempts over and over.
+func (sc SinkConfig) retryDelay() time.Duration {
+	if sc.RetrySeconds > 0 {
+		return time.Duration(sc.RetrySeconds) * time.Second
+	}
+	if sc.Type == "file" {
+		return 0
+	}
+	return reconnectInterval
+}
+
+func (sc SinkConfig) flushBatch() int {
+	if sc.BatchSize > 0 {
+		return sc.BatchSize
+	}
+	return defaultSinkBatch
+}
+
+func MakeSink(cfg *ClientConfig, sc SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case "file":
+		if len(sc.Path) == 0 {
+			return nil, fmt.Errorf("file sink needs a Path")
+		}
+		s := &fileSink{path: sc.Path}
+		switch sc.Format {
+		case "", "graphite":
+			s.format = func(obs Observation) string {
+				return graphiteLine(sc.Prefix, obs)
+			}
+		case "influx":
+			s.format = func(obs Observation) string {
+				return influxLine(sc.measurement(), obs)
+			}
+		default:
+			return nil, fmt.Errorf("unknown file format %s", sc.Format)
+		}
+		return s, nil
+	case "graphite":
+		if len(sc.Server) == 0 {
+			return nil, fmt.Errorf("graphite sink needs a Server")
+		}
+		return &graphiteSink{server: sc.Server, prefix: sc.Prefix}, nil
+	case "influxdb":
+		if len(sc.URL) == 0 || len(sc.Bucket) == 0 {
+			return nil, fmt.Errorf("influxdb sink needs a URL and Bucket")
+		}
+		return &influxSink{conf: sc}, nil
+	case "prometheus":
+		// the values are served up by the /metrics handler
+		return &promSink{set: cfg.Latest}, nil
+	case "remote_write":
+		if len(sc.URL) == 0 {
+			return nil, fmt.Errorf("remote_write sink needs a URL")
+		}
+		return &remoteWriteSink{conf: sc}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %s", sc.Type)
+}
+
+func (sc SinkConfig) measurement() string {
+	if len(sc.Measurement) == 0 {
+		return "oura"
+	}
+	return sc.Measurement
+}
+
+// SinkConfigs returns the Sinks list from the config file, or if
+// there isn't one, makes one out of the older LocalDataLog and
+// GraphiteServer settings.
+func (cfg *ClientConfig) SinkConfigs() []SinkConfig {
+	if len(cfg.Sinks) > 0 {
+		scs := make([]SinkConfig, len(cfg.Sinks))
+		copy(scs, cfg.Sinks)
+		for i := range scs {
+			if len(scs[i].Prefix) == 0 {
+				scs[i].Prefix = cfg.GraphitePrefix
+			}
+		}
+		return scs
+	}
+	scs := make([]SinkConfig, 0, 2)
+	if len(cfg.LocalDataLog) > 0 {
+		scs = append(scs, SinkConfig{
+			Type:   "file",
+			Path:   cfg.LocalDataLog,
+			Prefix: cfg.GraphitePrefix,
+		})
+	}
+	if len(cfg.GraphiteServer) > 0 {
+		scs = append(scs, SinkConfig{
+			Type:   "graphite",
+			Server: cfg.GraphiteServer,
+			Prefix: cfg.GraphitePrefix,
+		})
+	}
+	return scs
+}
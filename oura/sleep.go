@@ -0,0 +1,71 @@
+package oura
+
+// A day can have more than one sleep period: the night, plus naps,
+// plus rests, and sometimes the night gets broken in two.  If they all
+// went under sleep.*, a nap would land in the middle of the night's
+// series.  So the longest long_sleep of the day is the main one, and
+// gets sleep.*, and the others go under a prefix for their type:
+//
+//	sleep.short.*  type "sleep"
+//	sleep.nap.*    type "late_nap"
+//	sleep.rest.*   type "rest"
+//	sleep.extra.*  any long_sleep that isn't the longest
+//
+// sleep.nap_total is the total sleep in the naps (types "sleep" and
+// "late_nap"), at the dayTime of the day.  Rests and extra long_sleeps
+// aren't naps, so they don't count.
+
+var sleepTypePrefixes = map[string]string{
+	"sleep":      "short",
+	"late_nap":   "nap",
+	"rest":       "rest",
+	"long_sleep": "extra",
+}
+
+func sleepPrefix(sp sleepPeriod) string {
+	if p, ok := sleepTypePrefixes[sp.Type]; ok {
+		return p
+	}
+	if p := metricSafe(sp.Type); len(p) > 0 {
+		return p
+	}
+	return "unknown"
+}
+
+func sleepPeriods(docs []sleepPeriod, e *emitter) {
+	days := make([]string, 0)
+	by_day := make(map[string][]sleepPeriod)
+	for _, sp := range docs {
+		if by_day[sp.Day] == nil {
+			days = append(days, sp.Day)
+		}
+		by_day[sp.Day] = append(by_day[sp.Day], sp)
+	}
+	for _, day := range days {
+		periods := by_day[day]
+		main := -1
+		for i, sp := range periods {
+			if sp.Type == "long_sleep" && (main < 0 ||
+				sp.Total_sleep_duration > periods[main].Total_sleep_duration) {
+				main = i
+			}
+		}
+		nap_total := 0
+		for i, sp := range periods {
+			var c *emitter
+			if i == main {
+				c = e.child("", sp.GetTimestamp())
+			} else {
+				c = e.child(sleepPrefix(sp), sp.GetTimestamp())
+				if sp.Type == "sleep" || sp.Type == "late_nap" {
+					nap_total += sp.Total_sleep_duration
+				}
+			}
+			sp.extract(c)
+			e.count += c.count
+		}
+		if t := e.cfg.dayTime(e.username, day); !t.IsZero() {
+			e.sendTs("nap_total", float32(nap_total), t)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package oura
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSleeps = `[
+	{"id":"night","day":"2024-08-11","type":"long_sleep",
+	 "bedtime_start":"2024-08-10T23:00:00+00:00","total_sleep_duration":25000},
+	{"id":"extra","day":"2024-08-11","type":"long_sleep",
+	 "bedtime_start":"2024-08-11T04:00:00+00:00","total_sleep_duration":5000},
+	{"id":"nap","day":"2024-08-11","type":"late_nap",
+	 "bedtime_start":"2024-08-11T14:00:00+00:00","total_sleep_duration":1200},
+	{"id":"short","day":"2024-08-11","type":"sleep",
+	 "bedtime_start":"2024-08-11T17:00:00+00:00","total_sleep_duration":600},
+	{"id":"rest","day":"2024-08-11","type":"rest",
+	 "bedtime_start":"2024-08-11T19:00:00+00:00","total_sleep_duration":300}
+]`
+
+func TestSleepPeriodsNapTotal(t *testing.T) {
+	requests := make([]string, 0)
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/usercollection/sleep") {
+			fmt.Fprintf(w, `{"data":%s,"next_token":null}`, testSleeps)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	cfg := testConfig(t, 0, api)
+	dt := findDocType("sleep")
+
+	// the webhook path: the search around the event time finds the
+	// document, so there is no need to fetch it first
+	sink := make(chan Observation, 1000)
+	when := time.Date(2024, 8, 11, 20, 0, 0, 0, time.UTC)
+	if _, err := dt.fetch(cfg, dt, "bob", "nap", when, sink); err != nil {
+		t.Fatalf("fetch: %s", err)
+	}
+	got := collect(sink)
+	if len(requests) != 1 {
+		t.Errorf("webhook made requests %v, want one search", requests)
+	}
+
+	if n := got["sleep.nap_total"]; len(n) != 1 || n[0].Value != 1800 {
+		t.Errorf("nap_total is %v, want 1800", n)
+	}
+	for k, want := range map[string]float32{
+		"sleep.total_sleep_duration":       25000,
+		"sleep.extra.total_sleep_duration": 5000,
+		"sleep.nap.total_sleep_duration":   1200,
+		"sleep.short.total_sleep_duration": 600,
+		"sleep.rest.total_sleep_duration":  300,
+	} {
+		if len(got[k]) != 1 || got[k][0].Value != want {
+			t.Errorf("%s is %v, want %v", k, got[k], want)
+		}
+	}
+}
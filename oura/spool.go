@@ -0,0 +1,193 @@
+package oura
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// a spool is a file where observations wait while their sink is
+// down.  It holds graphite plaintext lines with no prefix, in the
+// order that they arrived.  It survives restarts, so whatever is in
+// it when the process starts goes out the first time the sink opens.
+// The sink's worker and the sender that spills into it when the worker
+// falls behind can both use it, so everything happens under mu.
+type spool struct {
+	mu      sync.Mutex
+	path    string
+	max     int64 // bytes
+	size    int64
+	dropped int
+	f       *os.File // open while the sink is down
+	w       *bufio.Writer
+}
+
+const defaultSpoolMax = 64 * 1024 * 1024
+
+func makeSpool(sc SinkConfig) *spool {
+	if len(sc.SpoolFile) == 0 {
+		return nil
+	}
+	sp := &spool{path: sc.SpoolFile, max: sc.SpoolMaxBytes}
+	if sp.max <= 0 {
+		sp.max = defaultSpoolMax
+	}
+	if stat, err := os.Stat(sp.path); err == nil {
+		sp.size = stat.Size()
+		if sp.size > 0 {
+			log.Printf("spool %s has %d bytes left over", sp.path, sp.size)
+		}
+	}
+	return sp
+}
+
+func (sp *spool) append(obslist []Observation) {
+	if len(obslist) == 0 {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f == nil {
+		f, err := os.OpenFile(sp.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0644)
+		if err != nil {
+			sp.drop(len(obslist), err)
+			return
+		}
+		sp.f = f
+		sp.w = bufio.NewWriter(f)
+	}
+	for i, obs := range obslist {
+		line := graphiteLine("", obs)
+		if sp.size+int64(len(line)) > sp.max {
+			sp.w.Flush()
+			sp.drop(len(obslist)-i, fmt.Errorf("spool is full"))
+			return
+		}
+		if _, err := io.WriteString(sp.w, line); err != nil {
+			sp.drop(len(obslist)-i, err)
+			sp.closeFile()
+			return
+		}
+		sp.size += int64(len(line))
+	}
+	if err := sp.w.Flush(); err != nil {
+		log.Printf("failed write to spool %s: %s", sp.path, err)
+		sp.closeFile()
+	}
+}
+
+func (sp *spool) empty() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.size == 0
+}
+
+// close lets go of the file, which append keeps open for as long as
+// the sink is down, so as not to open it again for every observation.
+func (sp *spool) close() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.closeFile()
+}
+
+func (sp *spool) closeFile() {
+	if sp.f == nil {
+		return
+	}
+	if err := sp.f.Close(); err != nil {
+		log.Printf("failed to close spool %s: %s", sp.path, err)
+	}
+	sp.f = nil
+	sp.w = nil
+}
+
+func (sp *spool) drop(n int, err error) {
+	before := sp.dropped
+	sp.dropped += n
+	// don't fill up the log with this; say something at 1, 1000, 2000...
+	if before == 0 || before/1000 != sp.dropped/1000 {
+		log.Printf("spool %s: %s; %d lines dropped so far", sp.path, err,
+			sp.dropped)
+	}
+}
+
+// replay sends everything in the spool to s, in order, and flushes
+// it.  If that fails partway, all of it stays in the spool, because
+// the sink could have been holding any of what we wrote in a buffer.
+// Sending some of it twice does no harm.  The lock isn't held while
+// we talk to the sink, which could be slow; whatever gets appended in
+// the meantime stays in the spool for next time.
+func (sp *spool) replay(s Sink) error {
+	sp.mu.Lock()
+	sp.closeFile()
+	buf, err := os.ReadFile(sp.path)
+	sp.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	replayed := 0
+	for _, line := range strings.SplitAfter(string(buf), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		obs, perr := ParseGraphiteLine("", line)
+		if perr != nil {
+			log.Printf("discarding bad spool line %q: %s", line, perr)
+			continue
+		}
+		if err = s.Write(obs); err != nil {
+			break
+		}
+		replayed += 1
+	}
+	if err == nil {
+		err = s.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("replayed %d lines from spool %s", replayed, sp.path)
+	return sp.forget(len(buf))
+}
+
+// forget takes the first n bytes, which have been replayed, off the
+// front of the spool.
+func (sp *spool) forget(n int) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.closeFile()
+	buf, err := os.ReadFile(sp.path)
+	if err != nil {
+		return err
+	}
+	if len(buf) <= n {
+		sp.size = 0
+		return os.Remove(sp.path)
+	}
+	f, err := os.CreateTemp(filepath.Dir(sp.path), ".spool-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf[n:])
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), sp.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	sp.size = int64(len(buf) - n)
+	return nil
+}
@@ -0,0 +1,110 @@
+package oura
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSink buffers writes like a real one does, and fails the write
+// after failAfter of them.  Only flushed observations count as sent.
+type fakeSink struct {
+	failAfter int
+	writes    int
+	buffered  []Observation
+	sent      []Observation
+}
+
+func (s *fakeSink) Open() error   { return nil }
+func (s *fakeSink) Close() error  { return nil }
+func (s *fakeSink) Healthy() bool { return true }
+
+func (s *fakeSink) Write(obs Observation) error {
+	if s.failAfter > 0 && s.writes >= s.failAfter {
+		return fmt.Errorf("broken pipe")
+	}
+	s.writes += 1
+	s.buffered = append(s.buffered, obs)
+	return nil
+}
+
+func (s *fakeSink) Flush() error {
+	s.sent = append(s.sent, s.buffered...)
+	s.buffered = nil
+	return nil
+}
+
+func spoolObs(n int) []Observation {
+	obslist := make([]Observation, n)
+	for i := range obslist {
+		obslist[i] = Observation{
+			Timestamp: time.Unix(int64(1719288000+i), 0),
+			Username:  "bob",
+			Field:     "heartrate.bpm",
+			Value:     float32(60 + i),
+		}
+	}
+	return obslist
+}
+
+func TestSpoolReplayWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	sp := makeSpool(SinkConfig{SpoolFile: path})
+	for _, obs := range spoolObs(5) {
+		sp.append([]Observation{obs})
+	}
+	if sp.f == nil {
+		t.Errorf("spool file was closed between appends")
+	}
+	before, _ := os.ReadFile(path)
+
+	// the first three only reach the sink's buffer, so none of them are
+	// safe to forget
+	s := &fakeSink{failAfter: 3}
+	if err := sp.replay(s); err == nil {
+		t.Fatalf("replay succeeded into a broken sink")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("spool is gone: %s", err)
+	}
+	if string(after) != string(before) || sp.size != int64(len(before)) {
+		t.Errorf("spool lost lines after a failed write:\n%s", after)
+	}
+
+	s = &fakeSink{}
+	if err := sp.replay(s); err != nil {
+		t.Fatalf("replay: %s", err)
+	}
+	want := spoolObs(5)
+	if len(s.sent) != len(want) {
+		t.Fatalf("replayed %d observations, want %d", len(s.sent), len(want))
+	}
+	for i := range want {
+		if s.sent[i].Value != want[i].Value ||
+			!s.sent[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("replayed %+v at %d, want %+v", s.sent[i], i, want[i])
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("spool still exists after a good replay")
+	}
+	if sp.size != 0 {
+		t.Errorf("spool size is %d after a good replay", sp.size)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	line := graphiteLine("", spoolObs(1)[0])
+	sp := makeSpool(SinkConfig{SpoolFile: path,
+		SpoolMaxBytes: int64(len(line) * 3)})
+	sp.append(spoolObs(5))
+	sp.close()
+	if sp.size != int64(len(line)*3) || sp.dropped != 2 {
+		t.Errorf("size %d dropped %d, want %d and 2", sp.size, sp.dropped,
+			len(line)*3)
+	}
+}
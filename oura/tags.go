@@ -0,0 +1,81 @@
+package oura
+
+import (
+	"strings"
+	"time"
+)
+
+// Tags are whatever the user wants to record with a timestamp,
+// including free text comments, so they are a privacy concern.  We
+// only ever record the tag codes that are in the TagAllowList or that
+// the user has opted into, and the structs don't have the comment
+// fields at all, so that they can't end up in a log or a sink by
+// accident.  Custom tags are never recorded, because the only thing
+// that says what they are is the custom_name the user typed in.
+
+// the older kind, which Oura says is deprecated
+type tag struct {
+	ID        string
+	Day       string
+	Timestamp time.Time
+	Tags      []string // tag codes like "tag_generic_alcohol"
+}
+
+// these can have an end, and only have one code each
+type enhancedTag struct {
+	ID            string
+	Tag_type_code string
+	Start_time    time.Time
+	End_time      *time.Time
+	Start_day     string
+	End_day       string
+}
+
+func (t tag) GetTimestamp() time.Time {
+	return t.Timestamp
+}
+
+func (et enhancedTag) GetTimestamp() time.Time {
+	return et.Start_time
+}
+
+func tagAllowed(cfg *ClientConfig, user string, code string) bool {
+	if len(code) == 0 || code == "custom" {
+		return false
+	}
+	for _, c := range cfg.TagAllowList {
+		if c == code {
+			return true
+		}
+	}
+	for _, c := range cfg.UserTokens.GetTagOptIn(user) {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// "tag_generic_alcohol" comes out as tag.generic_alcohol.event
+func tagMetric(code string) string {
+	return metricSafe(strings.TrimPrefix(code, "tag_"))
+}
+
+func (t tag) extract(e *emitter) {
+	for _, code := range t.Tags {
+		if tagAllowed(e.cfg, e.username, code) {
+			e.send(tagMetric(code)+".event", 1)
+		}
+	}
+}
+
+func (et enhancedTag) extract(e *emitter) {
+	if !tagAllowed(e.cfg, e.username, et.Tag_type_code) {
+		return
+	}
+	name := tagMetric(et.Tag_type_code)
+	e.send(name+".event", 1)
+	if et.End_time != nil && et.End_time.After(et.Start_time) {
+		e.send(name+".duration", float32(et.End_time.Sub(et.Start_time).Seconds()))
+	}
+}
@@ -0,0 +1,74 @@
+package oura
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Some documents only have a Day, like "2024-08-11", and no time.  If
+// we put those at midnight UTC, they land hours away from the same
+// day's readiness (which Oura gives us at midnight in the user's own
+// timezone), and a day early for anyone west of UTC.  So each user
+// has a location: the Timezone on their UserToken if it is set, or
+// else the UTC offset we learned from the last document that had one.
+// Date-only documents go at DayAnchor (default midnight) in that
+// location.
+
+// a dayDoc has only a date, not a time
+type dayDoc interface {
+	GetDay() string
+}
+
+// parseDayAnchor turns the DayAnchor setting, like "12:00", into an
+// offset from midnight
+func parseDayAnchor(s string) (time.Duration, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("DayAnchor %s is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute, nil
+}
+
+// UserLocation is where the user's days start and end.  It is UTC if
+// we don't know any better.
+func (cfg *ClientConfig) UserLocation(name string) *time.Location {
+	tz, offset := cfg.UserTokens.GetTimezone(name)
+	if len(tz) > 0 {
+		loc, err := time.LoadLocation(tz)
+		if err == nil {
+			return loc
+		}
+		log.Printf("bad Timezone %s for %s: %s", tz, name, err)
+	}
+	if offset != nil {
+		return time.FixedZone("", *offset)
+	}
+	return time.UTC
+}
+
+// dayTime is where the observations from a date-only document go.
+// If day doesn't parse, you get the time.Time zero value, the same as
+// GetTimestamp would give you.
+func (cfg *ClientConfig) dayTime(name string, day string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02", day,
+		cfg.UserLocation(name))
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Add(cfg.dayAnchor)
+}
+
+// learnOffset remembers the UTC offset of t, which should be from a
+// document that Oura gave us in the user's local time.
+func (cfg *ClientConfig) learnOffset(name string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	_, offset := t.Zone()
+	cfg.UserTokens.SetUtcOffset(name, offset)
+}
@@ -2,14 +2,16 @@ package oura
 
 import (
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// a Doc is any of the document structs below.  What to call them and
+// how to turn them into Observations is in registry.go.
 type Doc interface {
-	dailyReadiness | dailyActivity | dailySleep | sleepPeriod | heartrateInstant | dailySpo2 | dailyResilience | dailyStress
 	GetTimestamp() time.Time
-	GetMetricPrefix() string
 }
 
 type PersonalInfo struct {
@@ -69,15 +71,11 @@ type dailyActivity struct {
 	Timestamp                   time.Time
 }
 
-// this comes as a string, we want an int, so we need custom Marshal
-// and Unmarshal handlers
-type resilienceLevel int
-
 type dailyResilience struct {
 	ID           string
 	Day          string
 	Contributors map[string]float32 // watch out, this is unlike the others
-	Level        resilienceLevel
+	Level        string             // see enums.go
 }
 
 type dailySleep struct {
@@ -148,6 +146,94 @@ type dailyStress struct {
 	Day_summary   string
 }
 
+// one of these per workout, whether the ring noticed it or the user
+// entered it.  Calories and Distance are null for some activities.
+// There is also a Label, which is whatever the user typed in, so we
+// don't ask for it, and the type is private so that it doesn't end
+// up in the log either.
+type workout struct {
+	ID             string
+	Activity       string
+	Calories       *float32
+	Day            string
+	Distance       *float32
+	End_datetime   time.Time
+	Intensity      string
+	Source         string
+	Start_datetime time.Time
+}
+
+// a guided or unguided meditation, breathing exercise, etc. that the
+// user started in the app.  The series are null if the ring wasn't
+// worn, which leaves them empty.
+type session struct {
+	ID                     string
+	Day                    string
+	Start_datetime         time.Time
+	End_datetime           time.Time
+	Type                   string
+	Heart_rate             intervalMetric
+	Heart_rate_variability intervalMetric
+	Motion_count           intervalMetric
+}
+
+// these two are only ever one number per day, and the number is null
+// until there is enough data to work it out
+type vo2Max struct {
+	ID        string
+	Day       string
+	Timestamp time.Time
+	Vo2_max   *float32
+}
+
+type cardiovascularAge struct {
+	Day          string
+	Vascular_age *int
+}
+
+// Oura's bedtime advice.  The offsets are seconds from midnight at
+// the start of Day, so they are usually negative.
+type sleepTime struct {
+	ID              string
+	Day             string
+	Optimal_bedtime *struct {
+		Day_tz       int
+		End_offset   int
+		Start_offset int
+	}
+	Recommendation string
+	Status         string
+}
+
+// while someone is sick, or otherwise taking a break.  End_time is null
+// until they turn it off.  There are also episodes, which have tags
+// in them, so we leave those alone.
+type restModePeriod struct {
+	ID         string
+	Start_day  string
+	Start_time time.Time
+	End_day    string
+	End_time   *time.Time
+}
+
+// Oura doesn't document this one.  What we have seen is a Day and a
+// Phase; anything else in it is ignored.
+type cyclePhase struct {
+	ID    string
+	Day   string
+	Phase string
+}
+
+type ringConfiguration struct {
+	ID               string
+	Color            string
+	Design           string
+	Firmware_version string
+	Hardware_type    string
+	Set_up_at        time.Time
+	Size             int
+}
+
 type SearchResponse[D Doc] struct {
 	Data       []D
 	Next_token string
@@ -170,53 +256,43 @@ func (dr dailyReadiness) GetTimestamp() time.Time {
 	return dr.Timestamp
 }
 
-func (dr dailyReadiness) GetMetricPrefix() string {
-	return "readiness"
-}
-
 func (da dailyActivity) GetTimestamp() time.Time {
 	return da.Timestamp
 }
 
-func (da dailyActivity) GetMetricPrefix() string {
-	return "activity"
-}
-
 func (ds dailySleep) GetTimestamp() time.Time {
 	return ds.Timestamp
 }
 
-func (ds dailySleep) GetMetricPrefix() string {
-	return "sleep"
+func (sp sleepPeriod) GetID() string {
+	return sp.ID
 }
 
 func (sp sleepPeriod) GetTimestamp() time.Time {
 	return sp.Bedtime_end
 }
 
-func (sp sleepPeriod) GetMetricPrefix() string {
-	// danger of overwriting metrics from dailySleep document, but they
-	// don't appear to contain any of the same keys??
-	return "sleep"
-}
-
 func (hr heartrateInstant) GetTimestamp() time.Time {
 	return hr.Timestamp
 }
 
-func (hr heartrateInstant) GetMetricPrefix() string {
-	return "hr"
+// The next few documents lack Timestamp and have only Day.  SendDoc
+// uses GetDay to put them at the right time for the user (see
+// timezone.go); GetTimestamp is midnight UTC, for lack of anything
+// better.  You're getting the time.Time zero value if the parsing
+// fails, sorry.
+
+func (ds dailySpo2) GetDay() string {
+	return ds.Day
 }
 
 func (ds dailySpo2) GetTimestamp() time.Time {
-	// this document lacks Timestamp and has only Day.  So you're
-	// getting the time.Time zero value if the parsing fails, sorry.
 	t, _ := time.Parse("2006-01-02", ds.Day)
 	return t
 }
 
-func (ds dailySpo2) GetMetricPrefix() string {
-	return "spo2"
+func (dr dailyResilience) GetDay() string {
+	return dr.Day
 }
 
 func (dr dailyResilience) GetTimestamp() time.Time {
@@ -224,8 +300,8 @@ func (dr dailyResilience) GetTimestamp() time.Time {
 	return t
 }
 
-func (dr dailyResilience) GetMetricPrefix() string {
-	return "resilience"
+func (ds dailyStress) GetDay() string {
+	return ds.Day
 }
 
 func (ds dailyStress) GetTimestamp() time.Time {
@@ -233,39 +309,211 @@ func (ds dailyStress) GetTimestamp() time.Time {
 	return t
 }
 
-func (ds dailyStress) GetMetricPrefix() string {
-	return "stress"
+func (w workout) GetTimestamp() time.Time {
+	return w.Start_datetime
+}
+
+func (s session) GetTimestamp() time.Time {
+	return s.Start_datetime
+}
+
+// Timestamp is there, but it is just midnight UTC of Day, so treat it
+// like the others that only have a Day
+func (v vo2Max) GetDay() string {
+	return v.Day
+}
+
+func (v vo2Max) GetTimestamp() time.Time {
+	t, _ := time.Parse("2006-01-02", v.Day)
+	return t
+}
+
+func (ca cardiovascularAge) GetDay() string {
+	return ca.Day
+}
+
+func (ca cardiovascularAge) GetTimestamp() time.Time {
+	t, _ := time.Parse("2006-01-02", ca.Day)
+	return t
 }
 
-func (r *resilienceLevel) UnmarshalJSON(b []byte) error {
-	levels := map[string]resilienceLevel{
-		"limited":     1,
-		"adequate":    2,
-		"solid":       3, // I am assuming the words above "adequate" look like this
-		"strong":      4, // because I have never seen them lol
-		"exceptional": 5,
+func (v vo2Max) extract(e *emitter) {
+	if v.Vo2_max != nil {
+		e.send("value", *v.Vo2_max)
 	}
-	s := strings.Trim(string(b), "\"")
-	i, ok := levels[s]
-	if !ok {
-		*r = -1
-		return fmt.Errorf("unknown resilience level %s", s)
+}
+
+func (ca cardiovascularAge) extract(e *emitter) {
+	if ca.Vascular_age != nil {
+		e.send("vascular_age", float32(*ca.Vascular_age))
 	}
-	*r = i
-	return nil
 }
 
-func (r resilienceLevel) MarshalJSON() ([]byte, error) {
-	levels := map[resilienceLevel]string{
-		1: "limited",
-		2: "adequate",
-		3: "solid",
-		4: "strong",
-		5: "exceptional",
+func (st sleepTime) GetDay() string {
+	return st.Day
+}
+
+func (st sleepTime) GetTimestamp() time.Time {
+	t, _ := time.Parse("2006-01-02", st.Day)
+	return t
+}
+
+func (rm restModePeriod) GetTimestamp() time.Time {
+	return rm.Start_time
+}
+
+func (cp cyclePhase) GetDay() string {
+	return cp.Day
+}
+
+func (cp cyclePhase) GetTimestamp() time.Time {
+	t, _ := time.Parse("2006-01-02", cp.Day)
+	return t
+}
+
+func (rc ringConfiguration) GetTimestamp() time.Time {
+	return rc.Set_up_at
+}
+
+// the percentage is nested for no reason
+func (ds dailySpo2) extract(e *emitter) {
+	e.send("daily_average", ds.Spo2_percentage.Average)
+}
+
+// this one can almost get through sendFields, BUT the contributors
+// map has float values this time.
+func (dr dailyResilience) extract(e *emitter) {
+	if v, ok := e.enum("level", dr.Level); ok {
+		e.send("level", v)
+	}
+	for k, v := range dr.Contributors {
+		e.send(fmt.Sprintf("contrib.%s", strings.ToLower(k)), v)
+	}
+}
+
+// metricSafe squashes a string from a document into something that
+// can be one component of a metric name.
+func metricSafe(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// the activity ("walking", "cycling", ...) becomes part of the metric
+// name, so that each kind of workout is its own series.
+func (w workout) extract(e *emitter) {
+	activity := metricSafe(w.Activity)
+	if len(activity) == 0 {
+		activity = "unknown"
+	}
+	send := func(k string, v float32) {
+		e.send(activity+"."+k, v)
+	}
+	if w.Calories != nil {
+		send("calories", *w.Calories)
+	}
+	if w.Distance != nil {
+		send("distance", *w.Distance)
+	}
+	if !w.End_datetime.IsZero() && w.End_datetime.After(w.Start_datetime) {
+		send("duration", float32(w.End_datetime.Sub(w.Start_datetime).Seconds()))
+	}
+	if v, ok := e.enum("intensity", w.Intensity); ok {
+		send("intensity", v)
+	}
+}
+
+// the interval series and the type go through sendFields; the
+// duration has to be worked out.
+func (s session) extract(e *emitter) {
+	sendFields(s, e)
+	if s.End_datetime.After(s.Start_datetime) {
+		e.send("duration", float32(s.End_datetime.Sub(s.Start_datetime).Seconds()))
+	}
+}
+
+func (st sleepTime) extract(e *emitter) {
+	if ob := st.Optimal_bedtime; ob != nil {
+		e.send("bedtime_start_offset", float32(ob.Start_offset))
+		e.send("bedtime_end_offset", float32(ob.End_offset))
+	}
+	if v, ok := e.enum("status", st.Status); ok {
+		e.send("status", v)
+	}
+	if v, ok := e.enum("recommendation", st.Recommendation); ok {
+		e.send("recommendation", v)
+	}
+}
+
+// rest mode comes out as 1 when it starts and 0 when it ends, so that
+// it can be drawn as a state (with keepLastValue in graphite).
+func (rm restModePeriod) extract(e *emitter) {
+	e.send("active", 1)
+	if rm.End_time != nil && rm.End_time.After(rm.Start_time) {
+		e.sendTs("active", 0, *rm.End_time)
+		e.send("duration", float32(rm.End_time.Sub(rm.Start_time).Seconds()))
+	}
+}
+
+func (cp cyclePhase) extract(e *emitter) {
+	if v, ok := e.enum("phase", cp.Phase); ok {
+		e.send("phase", v)
+	}
+}
+
+// firmwareNumber turns "2.9.21" into 20921, so that it can be graphed
+// and compared.  Each part after the first is assumed to be under 100.
+func firmwareNumber(version string) (float32, bool) {
+	n := 0
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	for i := 0; i < 3; i++ {
+		n *= 100
+		if i < len(parts) {
+			p, err := strconv.Atoi(parts[i])
+			if err != nil || p < 0 {
+				return 0, false
+			}
+			n += p
+		}
+	}
+	return float32(n), true
+}
+
+// the hardware and size are as of when the ring was set up.  Firmware
+// upgrades don't have a time on them, so we send ring.firmware when we
+// notice the version is new to us, along with ring.firmware_changed = 1
+// if there was a different one before, which is meant to be drawn as
+// an annotation.
+func (rc ringConfiguration) extract(e *emitter) {
+	if v, ok := e.enum("hardware_type", rc.Hardware_type); ok {
+		e.send("hardware_type", v)
+	}
+	if rc.Size > 0 {
+		e.send("size", float32(rc.Size))
+	}
+	if len(rc.Firmware_version) == 0 {
+		return
+	}
+	old := e.cfg.UserTokens.SwapFirmware(e.username, rc.ID, rc.Firmware_version)
+	if old == rc.Firmware_version {
+		return
+	}
+	now := time.Now()
+	if v, ok := firmwareNumber(rc.Firmware_version); ok {
+		e.sendTs("firmware", v, now)
+	} else {
+		log.Printf("can't make sense of firmware version %s", rc.Firmware_version)
 	}
-	s, ok := levels[r]
-	if !ok {
-		return nil, fmt.Errorf("unknown resilience level %d", r)
+	if len(old) > 0 {
+		log.Printf("%s ring firmware changed from %s to %s", e.username, old,
+			rc.Firmware_version)
+		e.sendTs("firmware_changed", 1, now)
 	}
-	return []byte(s), nil
 }
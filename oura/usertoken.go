@@ -10,6 +10,20 @@ type UserToken struct {
 	PI         PersonalInfo
 	OauthToken oauth2.Token
 	LastUse    time.Time
+	// when each document type was last searched successfully
+	Checkpoints map[string]time.Time `json:",omitempty"`
+	// tag codes this user wants recorded, on top of TagAllowList
+	TagOptIn []string `json:",omitempty"`
+	// don't send weight, height, or bmi for this user
+	ProfileOptOut bool `json:",omitempty"`
+	// document types that are only fetched for users who ask
+	DocTypeOptIn []string `json:",omitempty"`
+	// the last firmware version we saw on each ring, by ring id
+	Firmware map[string]string `json:",omitempty"`
+	// IANA name like "America/New_York", if you want to set it
+	Timezone string `json:",omitempty"`
+	// seconds east of UTC, learned from documents, if Timezone isn't set
+	UtcOffset *int `json:",omitempty"`
 }
 
 func (ut *UserToken) CensorToken() string {
@@ -19,4 +33,3 @@ func (ut *UserToken) CensorToken() string {
 	}
 	return tok
 }
-
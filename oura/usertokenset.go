@@ -80,6 +80,46 @@ func (set *UserTokenSet) Touch(name string) error {
 	return nil
 }
 
+func (set *UserTokenSet) GetCheckpoint(name string, endpoint string) time.Time {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return time.Time{}
+	}
+	return ut.Checkpoints[endpoint]
+}
+
+func (set *UserTokenSet) GetTagOptIn(name string) []string {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return nil
+	}
+	return ut.TagOptIn
+}
+
+func (set *UserTokenSet) SetCheckpoint(name string, endpoint string,
+	t time.Time) error {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return fmt.Errorf("no token by the name %s", name)
+	}
+	// ut is a copy, but the map inside it is not
+	cp := make(map[string]time.Time, len(ut.Checkpoints)+1)
+	for k, v := range ut.Checkpoints {
+		cp[k] = v
+	}
+	cp[endpoint] = t
+	ut.Checkpoints = cp
+	set.tokens[name] = *ut
+	set.saveordie()
+	return nil
+}
+
 func (set *UserTokenSet) StorePersonalInfo(name string,
 	pi *PersonalInfo) error {
 	set.Lock.Lock()
@@ -94,6 +134,90 @@ func (set *UserTokenSet) StorePersonalInfo(name string,
 	return nil
 }
 
+func (set *UserTokenSet) GetPersonalInfo(name string) PersonalInfo {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return PersonalInfo{}
+	}
+	return ut.PI
+}
+
+func (set *UserTokenSet) GetProfileOptOut(name string) bool {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return true
+	}
+	return ut.ProfileOptOut
+}
+
+func (set *UserTokenSet) GetDocTypeOptIn(name string) []string {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return nil
+	}
+	return ut.DocTypeOptIn
+}
+
+// SwapFirmware records the firmware version on one of the user's
+// rings, and returns the one that was there before, if any.
+func (set *UserTokenSet) SwapFirmware(name string, ring string,
+	version string) string {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return ""
+	}
+	old := ut.Firmware[ring]
+	if old == version {
+		return old
+	}
+	// ut is a copy, but the map inside it is not
+	fw := make(map[string]string, len(ut.Firmware)+1)
+	for k, v := range ut.Firmware {
+		fw[k] = v
+	}
+	fw[ring] = version
+	ut.Firmware = fw
+	set.tokens[name] = *ut
+	set.saveordie()
+	return old
+}
+
+func (set *UserTokenSet) GetTimezone(name string) (string, *int) {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return "", nil
+	}
+	return ut.Timezone, ut.UtcOffset
+}
+
+// SetUtcOffset only saves the file if the offset changed, which is
+// twice a year for most people
+func (set *UserTokenSet) SetUtcOffset(name string, offset int) error {
+	set.Lock.Lock()
+	defer set.Lock.Unlock()
+	ut := set.findByName(name)
+	if ut == nil {
+		return fmt.Errorf("no token by the name %s", name)
+	}
+	if ut.UtcOffset != nil && *ut.UtcOffset == offset {
+		return nil
+	}
+	ut.UtcOffset = &offset
+	set.tokens[name] = *ut
+	set.saveordie()
+	return nil
+}
+
 func (set *UserTokenSet) GetOauthToken(name string) *oauth2.Token {
 	ut := set.findByName(name)
 	if ut == nil {
@@ -157,6 +281,14 @@ func (set *UserTokenSet) IsNew(user string) bool {
 	return ut.LastUse.IsZero()
 }
 
+func (set *UserTokenSet) LastUse(user string) time.Time {
+	ut := set.findByName(user)
+	if ut == nil {
+		return time.Time{}
+	}
+	return ut.LastUse
+}
+
 func (set *UserTokenSet) FindNameById(id string) (string, error) {
 	for _, i := range set.tokens {
 		if i.PI.ID == id {
@@ -40,9 +40,15 @@ func webhookReq(cfg *ClientConfig, method string, id string,
 	req.Header.Set("x-client-id", cfg.OauthConfig.ClientID)
 	req.Header.Set("x-client-secret", cfg.OauthConfig.ClientSecret)
 	log.Printf("doing %s %s, body is %s", method, dest, body)
+	// these aren't retried (the caller has its own ideas about that),
+	// but they still count against the rate limit
+	cfg.limiter.wait()
 	if res, err = client.Do(req); err != nil {
 		return nil, fmt.Errorf("failed to Do request: %s", err)
 	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		cfg.limiter.holdoff(retryAfter(res))
+	}
 	if body, err = validResponseBody(res); err != nil {
 		return nil, err
 	}
@@ -106,84 +112,50 @@ func RenewSubscription(cfg *ClientConfig, sub *subResponse) error {
 	return json.Unmarshal(body, sub)
 }
 
+// ProcessEvent fetches the document that event is about.  It only
+// returns an error if trying again later might work; notifications
+// that we can't or don't want to do anything with, and documents that
+// Oura won't give us (4xx), are just logged.
 func ProcessEvent(cfg *ClientConfig, event EventNotification,
-	sink chan<- Observation) {
+	sink chan<- Observation) error {
 
 	user, err := cfg.UserTokens.FindNameById(event.User_id)
 	if err != nil {
 		log.Printf("webhook notification for unknown userid %s", event.User_id)
-		return
+		return nil
 	}
 
 	log.Printf("received webhook notification for %s/%s/%s",
 		user, event.Event_type, event.Data_type)
-	if event.Event_type == "update" || event.Event_type == "create" {
+	if event.Event_type != "update" && event.Event_type != "create" {
 		// the other possible Event_type is "delete" and there is nothing we
 		// can do with that.
-		var err error
-		var i int
-		switch event.Data_type {
-		case "daily_activity":
-			da := dailyActivity{}
-			err = GetDocByID(cfg, user, "daily_activity", event.Object_id, &da)
-			if err == nil {
-				i = SendDoc(da, user, sink)
-			}
-		case "daily_readiness":
-			dr := dailyReadiness{}
-			err = GetDocByID(cfg, user, "daily_readiness", event.Object_id, &dr)
-			if err == nil {
-				i = SendDoc(dr, user, sink)
-			}
-		case "daily_sleep":
-			ds := dailySleep{}
-			err = GetDocByID(cfg, user, "daily_sleep", event.Object_id, &ds)
-			if err == nil {
-				i = SendDoc(ds, user, sink)
-			}
-		case "sleep":
-			sp := sleepPeriod{}
-			err = GetDocByID(cfg, user, "sleep", event.Object_id, &sp)
-			if err == nil {
-				i = SendDoc(sp, user, sink)
-			}
-		case "daily_spo2":
-			ds := dailySpo2{}
-			err = GetDocByID(cfg, user, "daily_spo2", event.Object_id, &ds)
-			if err == nil {
-				i = SendDoc(ds, user, sink)
-			}
-			// there is no such thing as daily_resilience subscription as of
-			// 2024-08-11.
-			/*
-				case "daily_resilience":
-					dr := dailyResilience{}
-					err = GetDocByID(cfg, user, "daily_resilience", event.Object_id, &dr)
-					if err == nil {
-						i = SendDoc(dr, user, sink)
-					}
-			*/
-		case "daily_stress":
-			ds := dailyStress{}
-			err = GetDocByID(cfg, user, "daily_stress", event.Object_id, &ds)
-			if err == nil {
-				i = SendDoc(ds, user, sink)
-			}
-		default:
-			// unhandled types include:
-			// tag enhanced_tag workout session daily_spo2 sleep_time
-			// rest_mode_period ring_configuration daily_stress
-			// daily_cycle_phases
-			err = fmt.Errorf("unhandled notification type: %s", event.Data_type)
-		}
-		if err != nil {
-			log.Printf("failed to retrieve document %s: %s", event.Object_id, err)
-		} else {
-			log.Printf("%s document id=%s processed for %d observations",
-				event.Data_type, event.Object_id, i)
-			cfg.UserTokens.Touch(user)
+		return nil
+	}
+	dt := findDocType(event.Data_type)
+	if dt == nil || !dt.webhook || !cfg.docTypeEnabled(dt) {
+		log.Printf("unhandled notification type: %s", event.Data_type)
+		return nil
+	}
+	if !cfg.userWants(user, dt) {
+		// the subscription is for everybody, but this user didn't opt in
+		log.Printf("ignoring %s notification for %s", event.Data_type, user)
+		return nil
+	}
+	i, err := dt.fetch(cfg, dt, user, event.Object_id, event.Event_time,
+		sink)
+	if err != nil {
+		log.Printf("failed to retrieve document %s: %s", event.Object_id, err)
+		if isNotFound(err) {
+			// the document got deleted since
+			return nil
 		}
+		return err
 	}
+	log.Printf("%s document id=%s processed for %d observations",
+		event.Data_type, event.Object_id, i)
+	cfg.UserTokens.Touch(user)
+	return nil
 }
 
 func ValidateSubscriptions(cfg *ClientConfig) {
@@ -223,8 +195,11 @@ func ValidateSubscriptions(cfg *ClientConfig) {
 		return false
 	}
 
-	for _, data_type := range []string{"daily_activity", "daily_readiness",
-		"daily_sleep", "sleep", "daily_spo2", "daily_stress"} {
+	for _, dt := range cfg.enabledDocTypes() {
+		if !dt.webhook {
+			continue
+		}
+		data_type := dt.endpoint
 		for _, event_type := range []string{"create", "update"} {
 			_, sub := cfg.Subscriptions.Find(data_type, event_type)
 			if sub == nil {
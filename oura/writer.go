@@ -2,10 +2,11 @@ package oura
 
 import (
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,95 +17,347 @@ type Observation struct {
 	Value     float32
 }
 
-// StoreObservations uses the LocalDataLog, GraphiteServer, and
-// GraphitePrefix entries in cfg.  It tries to os.OpenFile the path in
-// cfg.LocalDataLog, and tries to net.Dial the address in
-// cfg.GraphiteServer.  It closes and reopens them both every 15
-// minutes.  When there is a write error to the local file, or when
-// cfg.Reconnect == true, it tries to reopen both immediately.  When
-// there is a write error to graphite, it lets the 15-minly reconnect
-// try again.  If at any time neither LocalLogFile nor GraphiteServer
-// is working, then the process dies with a log.Fatalf.
+// the first component of Field is always the document type (see
+// SendDoc), and the rest is the metric name within that document.
+func (obs Observation) SplitField() (string, string) {
+	doc_type, metric, found := strings.Cut(obs.Field, ".")
+	if !found {
+		return "", obs.Field
+	}
+	return doc_type, metric
+}
 
-func StoreObservations(cfg *ClientConfig, src chan Observation) {
-	var local *os.File
-	var graphite net.Conn
-	var have_local, have_graphite bool
-	var err error
-	var next_reconnect time.Time
+func graphiteLine(prefix string, obs Observation) string {
+	return fmt.Sprintf("%s%s.%s %f %d\n",
+		prefix,
+		obs.Username,
+		obs.Field,
+		obs.Value,
+		obs.Timestamp.Unix())
+}
+
+// ParseGraphiteLine turns a graphite plaintext line back into an
+// Observation, if it starts with prefix.
+func ParseGraphiteLine(prefix string, line string) (Observation, error) {
+	obs := Observation{}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return obs, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	if !strings.HasPrefix(fields[0], prefix) {
+		return obs, fmt.Errorf("metric does not start with %s", prefix)
+	}
+	user, field, found := strings.Cut(fields[0][len(prefix):], ".")
+	if !found || len(user) == 0 || len(field) == 0 {
+		return obs, fmt.Errorf("can't find username in %s", fields[0])
+	}
+	v, err := strconv.ParseFloat(fields[1], 32)
+	if err != nil {
+		return obs, err
+	}
+	ts, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return obs, err
+	}
+	obs.Username = user
+	obs.Field = field
+	obs.Value = float32(v)
+	obs.Timestamp = time.Unix(ts, 0)
+	return obs, nil
+}
+
+// a sinkWorker owns one Sink and the goroutine that feeds it, so that
+// a slow or hung sink only backs up its own queue.
+type sinkWorker struct {
+	conf           SinkConfig
+	sink           Sink
+	queue          chan Observation
+	group          *sinkGroup
+	up             atomic.Bool // readable from outside the worker goroutine
+	retry_at       time.Time
+	next_reconnect time.Time
+	spool          *spool        // nil unless SpoolFile is configured
+	pending        []Observation // written but not flushed, only if spooling
+	unflushed      int
+	// these two belong to the sender, not the worker goroutine
+	overflow bool // the queue was full the last time we waited on it
+	dropped  int
+}
+
+type sinkGroup struct {
+	workers []*sinkWorker
+	wg      sync.WaitGroup
+}
+
+// the sinks are flushed at least this often, and when BatchSize
+// observations have been written since the last time
+const sinkFlushInterval = 5 * time.Second
+
+// how long the sender waits on a full queue, for any sink but "file",
+// before the observations go to the spool instead
+var sinkSendTimeout = 10 * time.Second
 
-	close := func() {
-		if have_local {
-			local.Close()
-			have_local = false
+func (w *sinkWorker) reopen() {
+	if w.sink.Healthy() && w.flush() != nil && w.spool != nil {
+		w.spool.append(w.pending)
+	}
+	w.pending = w.pending[:0]
+	w.unflushed = 0
+	w.sink.Close()
+	if err := w.sink.Open(); err != nil {
+		log.Printf("can't open sink %s: %s", w.conf, err)
+		w.retry_at = time.Now().Add(w.conf.retryDelay())
+	} else if w.spool != nil && !w.spool.empty() {
+		// the spooled observations have to go out before any new ones
+		if err := w.spool.replay(w.sink); err != nil {
+			log.Printf("failed to replay spool to %s: %s", w.conf, err)
+			w.sink.Close()
+			w.retry_at = time.Now().Add(w.conf.retryDelay())
 		}
-		if have_graphite {
-			graphite.Close()
-			have_graphite = false
+	}
+	// with a spool, observations are safe even when the sink is down
+	w.up.Store(w.sink.Healthy() || w.spool != nil)
+}
+
+// maybeReopen reopens the sink every reconnectInterval, or when it is
+// down and it's time to try again.
+func (w *sinkWorker) maybeReopen() {
+	now := time.Now()
+	if now.After(w.next_reconnect) ||
+		(!w.sink.Healthy() && !now.Before(w.retry_at)) {
+		w.reopen()
+		w.group.checkAlive()
+		w.next_reconnect = now.Add(reconnectInterval)
+	}
+}
+
+// write sends obs to the sink, and flushes once a batch has piled up
+// unflushed.  Anything less waits for the next tick.
+func (w *sinkWorker) write(obs Observation) error {
+	if w.spool != nil {
+		w.pending = append(w.pending, obs)
+	}
+	err := w.sink.Write(obs)
+	if err == nil {
+		if w.unflushed += 1; w.unflushed >= w.conf.flushBatch() {
+			err = w.flush()
 		}
 	}
+	return err
+}
+
+func (w *sinkWorker) flush() error {
+	err := w.sink.Flush()
+	if err == nil {
+		w.pending = w.pending[:0]
+		w.unflushed = 0
+	}
+	return err
+}
+
+// tick pushes out whatever the sink is buffering, and once the queue
+// has caught up, sends along anything that spilled into the spool
+// while the sink was slow.
+func (w *sinkWorker) tick() {
+	w.maybeReopen()
+	if !w.sink.Healthy() {
+		return
+	}
+	var err error
+	if w.unflushed > 0 {
+		err = w.flush()
+	}
+	if err == nil && w.spool != nil && len(w.queue) == 0 &&
+		!w.spool.empty() {
+		err = w.spool.replay(w.sink)
+	}
+	if err != nil {
+		w.failed(err)
+	}
+}
+
+func (w *sinkWorker) failed(err error) {
+	log.Printf("failed write to sink %s: %s", w.conf, err)
+	if w.spool != nil {
+		// we don't know which of these made it, so save them all
+		w.spool.append(w.pending)
+		w.pending = w.pending[:0]
+	}
+	w.unflushed = 0
+	w.sink.Close()
+	w.up.Store(w.spool != nil)
+	now := time.Now()
+	w.retry_at = now.Add(w.conf.retryDelay())
+	if !now.Before(w.retry_at) {
+		w.reopen()
+		w.group.checkAlive()
+	}
+}
 
-	reconnect := func() {
-		if len(cfg.LocalDataLog) > 0 {
-			local, err = os.OpenFile(cfg.LocalDataLog,
-				os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("can't open log file: %s", err)
-			} else {
-				log.Printf("opened local log file %s", cfg.LocalDataLog)
-				have_local = true
+func (w *sinkWorker) run() {
+	defer w.group.wg.Done()
+	defer w.sink.Close()
+	w.next_reconnect = time.Now().Add(reconnectInterval)
+	ticker := time.NewTicker(sinkFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case obs, ok := <-w.queue:
+			if !ok {
+				w.finish()
+				return
+			}
+			w.maybeReopen()
+			if !w.sink.Healthy() {
+				if w.spool != nil {
+					w.spool.append([]Observation{obs})
+				}
+				continue
 			}
+			if err := w.write(obs); err != nil {
+				w.failed(err)
+			}
+		case <-ticker.C:
+			w.tick()
 		}
-		if len(cfg.GraphiteServer) > 0 {
-			graphite, err = net.Dial("tcp", cfg.GraphiteServer)
-			if err != nil {
-				log.Printf("can't connect to graphite server: %s", err)
-			} else {
-				log.Printf("connected to graphite receiver at %s",
-					cfg.GraphiteServer)
-				have_graphite = true
+	}
+}
+
+func (w *sinkWorker) finish() {
+	if w.sink.Healthy() {
+		if err := w.flush(); err != nil {
+			log.Printf("failed final flush to sink %s: %s", w.conf, err)
+			if w.spool != nil {
+				w.spool.append(w.pending)
 			}
 		}
+	}
+	if w.spool != nil {
+		w.spool.close()
+	}
+}
 
-		if !(have_local || have_graphite) {
-			log.Fatalf("neither local log file nor graphite server is working")
+// spill is for when the worker can't keep up: the observation goes to
+// the spool, if there is one, without waiting in the queue.
+func (w *sinkWorker) spill(obs Observation) {
+	if w.spool != nil {
+		w.spool.append([]Observation{obs})
+		return
+	}
+	if w.dropped += 1; w.dropped%100 == 1 {
+		log.Printf("sink %s is backed up and has no SpoolFile, "+
+			"%d observations dropped", w.conf, w.dropped)
+	}
+}
+
+func startSinkGroup(cfg *ClientConfig) *sinkGroup {
+	g := &sinkGroup{}
+	for _, sc := range cfg.SinkConfigs() {
+		s, err := MakeSink(cfg, sc)
+		if err != nil {
+			log.Printf("skipping bad sink config %s: %s", sc, err)
+			continue
+		}
+		if sc.BufferSize <= 0 {
+			sc.BufferSize = defaultSinkBuffer
 		}
+		w := &sinkWorker{
+			conf:  sc,
+			sink:  s,
+			queue: make(chan Observation, sc.BufferSize),
+			group: g,
+			spool: makeSpool(sc),
+		}
+		w.reopen()
+		g.workers = append(g.workers, w)
+	}
+	g.checkAlive()
+	for _, w := range g.workers {
+		g.wg.Add(1)
+		go w.run()
 	}
+	return g
+}
 
-	defer close()
-	cfg.Reconnect = true
-	for obs := range src {
-		if cfg.Reconnect || time.Now().After(next_reconnect) {
-			close()
-			reconnect()
-			cfg.Reconnect = false
-			next_reconnect = time.Now().Add(15 * time.Minute)
+func (g *sinkGroup) stop() {
+	for _, w := range g.workers {
+		close(w.queue)
+	}
+	g.wg.Wait()
+}
+
+// if we are unable to record the observations anywhere, it is best
+// to die
+func (g *sinkGroup) checkAlive() {
+	for _, w := range g.workers {
+		if w.up.Load() {
+			return
+		}
+	}
+	log.Fatalf("none of the %d configured sinks is working", len(g.workers))
+}
+
+// send hands obs to every sink.  When a queue is full, we wait for a
+// "file" sink as long as it takes.  For the others we wait up to
+// sinkSendTimeout, and then, rather than let one dead server hold up
+// everything, spill into its spool until the queue has room again.
+func (g *sinkGroup) send(obs Observation) {
+	for _, w := range g.workers {
+		select {
+		case w.queue <- obs:
+			w.overflow = false
+			continue
+		default:
 		}
-		line := fmt.Sprintf("%s%s.%s %f %d\n",
-			cfg.GraphitePrefix,
-			obs.Username,
-			obs.Field,
-			obs.Value,
-			obs.Timestamp.Unix())
-		if have_local {
-			if _, err = io.WriteString(local, line); err != nil {
-				// if we are unable to record the observations, it is best to die
-				log.Printf("failed write to log file: %s", err)
-				local.Close()
-				have_local = false
-				// it is a local file, so try to reopen it immediately
-				cfg.Reconnect = true
+		if w.conf.Type == "file" {
+			w.queue <- obs
+			continue
+		}
+		if !w.overflow {
+			timer := time.NewTimer(sinkSendTimeout)
+			select {
+			case w.queue <- obs:
+				timer.Stop()
+				continue
+			case <-timer.C:
 			}
+			log.Printf("sink %s is backed up, spilling to its spool", w.conf)
+			w.overflow = true
 		}
-		if have_graphite {
-			if _, err = io.WriteString(graphite, line); err != nil {
-				log.Printf("failed write to graphite server: %s", err)
-				graphite.Close()
-				have_graphite = false
-				// we will try again at the next reconnect interval, rather
-				// than wait for a probably-hanging TCP connect attempt over
-				// and over
+		w.spill(obs)
+	}
+}
+
+// StoreObservations copies every Observation from src to each of the
+// sinks from cfg.SinkConfigs().  Each sink has its own goroutine and
+// its own queue of BufferSize observations.  When a sink falls that
+// far behind, we wait for it (see send), so src backs up and nothing
+// is lost unless a sink with no SpoolFile stays stuck.  Sinks are
+// flushed every BatchSize observations and every 5 seconds.  Every
+// sink is closed and reopened every 15 minutes.  When
+// cfg.Reconnect == true, all of the sinks are torn down and rebuilt
+// from the (presumably reloaded) config.  After a write error, a
+// "file" sink is reopened immediately, and other kinds wait
+// RetrySeconds or until the 15-minly reconnect.  If at any time none
+// of the sinks is working, then the process dies with a log.Fatalf.
+// When src is closed, StoreObservations flushes and closes all of the
+// sinks before it returns.
+
+func StoreObservations(cfg *ClientConfig, src chan Observation) {
+	var group *sinkGroup
+
+	cfg.Reconnect = true
+	for obs := range src {
+		if cfg.Reconnect {
+			if group != nil {
+				group.stop()
 			}
+			group = startSinkGroup(cfg)
+			cfg.Reconnect = false
 		}
+		group.send(obs)
+	}
+	if group != nil {
+		group.stop()
 	}
 }
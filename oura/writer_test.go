@@ -0,0 +1,87 @@
+package oura
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowSink doesn't take a write until gate is closed
+type slowSink struct {
+	fakeSink
+	gate chan struct{}
+}
+
+func (s *slowSink) Write(obs Observation) error {
+	<-s.gate
+	return s.fakeSink.Write(obs)
+}
+
+func startTestWorker(sc SinkConfig, s Sink) (*sinkGroup, *sinkWorker) {
+	g := &sinkGroup{}
+	w := &sinkWorker{
+		conf:  sc,
+		sink:  s,
+		queue: make(chan Observation, sc.BufferSize),
+		group: g,
+		spool: makeSpool(sc),
+	}
+	w.reopen()
+	g.workers = append(g.workers, w)
+	g.wg.Add(1)
+	go w.run()
+	return g, w
+}
+
+func TestSinkSendSpills(t *testing.T) {
+	defer func(d time.Duration) { sinkSendTimeout = d }(sinkSendTimeout)
+	sinkSendTimeout = 50 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "spool")
+	s := &slowSink{gate: make(chan struct{})}
+	g, w := startTestWorker(SinkConfig{Type: "graphite", BufferSize: 2,
+		SpoolFile: path}, s)
+
+	// one stuck in Write, two in the queue, and the rest should spill
+	// after waiting only once
+	start := time.Now()
+	for _, obs := range spoolObs(10) {
+		g.send(obs)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("send took %s", d)
+	}
+	close(s.gate)
+	g.stop()
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("nothing spilled: %s", err)
+	}
+	spilled := strings.Count(string(buf), "\n")
+	if len(s.sent)+spilled != 10 || w.dropped != 0 {
+		t.Errorf("sent %d spilled %d dropped %d, want 10 in all",
+			len(s.sent), spilled, w.dropped)
+	}
+}
+
+func TestSinkSendWaitsForFile(t *testing.T) {
+	defer func(d time.Duration) { sinkSendTimeout = d }(sinkSendTimeout)
+	sinkSendTimeout = time.Millisecond
+
+	s := &slowSink{gate: make(chan struct{})}
+	g, w := startTestWorker(SinkConfig{Type: "file", BufferSize: 1}, s)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(s.gate)
+	}()
+	for _, obs := range spoolObs(5) {
+		g.send(obs)
+	}
+	g.stop()
+	if len(s.sent) != 5 || w.dropped != 0 {
+		t.Errorf("sent %d dropped %d, want 5 and 0", len(s.sent), w.dropped)
+	}
+}
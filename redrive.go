@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mdickers47/ourabridge/oura"
+)
+
+// redriveMain puts the events in the EventDeadLetterFile back in the
+// event queue, with their tries reset, and takes them out of the file.
+// It is safe to run while the server is running: the server picks them
+// up the next time it looks at the queue.
+func redriveMain(args []string) {
+	cc := oura.LoadClientConfig(*ClientFile)
+	Cfg = &cc
+
+	fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+	dtype := fs.String("type", "",
+		"Only redrive events for this document type, e.g. sleep")
+	fs.Usage = func() {
+		log.Printf("usage: %s [flags] redrive [redrive flags]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// move the file out of the way first, so that anything the server
+	// gives up on while we work goes into a new one instead of getting
+	// lost when we put back the ones we didn't redrive.
+	fname := Cfg.EventDeadLetterFile
+	work := fname + ".redrive"
+	if _, err := os.Stat(work); err == nil {
+		// a redrive that didn't finish; renaming over it would lose those
+		log.Fatalf("%s is left over from an earlier redrive; put its lines "+
+			"back in %s, or delete it, and try again", work, fname)
+	}
+	if err := os.Rename(fname, work); errors.Is(err, os.ErrNotExist) {
+		log.Printf("%s doesn't exist; nothing to redrive", fname)
+		return
+	} else if err != nil {
+		log.Fatalf("can't move %s aside: %s", fname, err)
+	}
+	events, keep, err := oura.ReadDeadLetters(work)
+	if err != nil {
+		log.Fatalf("can't read %s: %s (it is still there)", work, err)
+	}
+	if len(keep) > 0 {
+		log.Printf("%d lines in %s don't parse; leaving them", len(keep), fname)
+	}
+
+	queue := oura.MakeEventQueue(Cfg)
+	sent := 0
+	for _, qe := range events {
+		if len(*dtype) > 0 && qe.Event.Data_type != *dtype {
+			buf, _ := json.Marshal(qe)
+			keep = append(keep, string(buf))
+			continue
+		}
+		if err := queue.Enqueue(qe.Event); err != nil {
+			log.Printf("can't queue %s/%s: %s", qe.Event.Data_type,
+				qe.Event.Object_id, err)
+			buf, _ := json.Marshal(qe)
+			keep = append(keep, string(buf))
+			continue
+		}
+		sent += 1
+	}
+
+	if len(keep) > 0 {
+		f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("can't put %d events back in %s: %s (they are still in %s)",
+				len(keep), fname, err, work)
+		}
+		for _, line := range keep {
+			if _, err = f.WriteString(line + "\n"); err != nil {
+				break
+			}
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatalf("failed write to %s: %s (the events are still in %s)",
+				fname, err, work)
+		}
+	}
+	if err := os.Remove(work); err != nil {
+		log.Printf("can't remove %s: %s", work, err)
+	}
+	log.Printf("redrove %d events into %s, %d left in %s", sent,
+		Cfg.EventQueueDir, len(keep), fname)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mdickers47/ourabridge/oura"
+)
+
+// parseDate accepts either 2006-01-02 or a full RFC3339 time.  A
+// date is midnight in loc.
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
+func parseDateFlag(name string, s string, loc *time.Location) time.Time {
+	if len(s) == 0 {
+		return time.Time{}
+	}
+	t, err := parseDate(s, loc)
+	if err != nil {
+		log.Fatalf("can't parse -%s %s: %s", name, s, err)
+	}
+	return t
+}
+
+// sameFile says whether a and b are the same file, even if they are
+// spelled differently.  If either one doesn't exist yet, the best we
+// can do is compare the cleaned-up absolute paths.
+func sameFile(a string, b string) bool {
+	sa, erra := os.Stat(a)
+	sb, errb := os.Stat(b)
+	if erra == nil && errb == nil {
+		return os.SameFile(sa, sb)
+	}
+	absa, erra := filepath.Abs(a)
+	absb, errb := filepath.Abs(b)
+	if erra != nil || errb != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absa == absb
+}
+
+// replayMain reads a file of graphite plaintext lines, such as the
+// LocalDataLog, and pushes them back through the configured sinks.
+// This is how you rebuild a graphite server that got wiped, or load
+// your history into a new kind of sink.
+func replayMain(args []string) {
+	cc := oura.LoadClientConfig(*ClientFile)
+	Cfg = &cc
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	prefix := fs.String("prefix", Cfg.GraphitePrefix,
+		"Metric prefix used in the file, which is stripped off")
+	start := fs.String("start", "",
+		"Skip observations before this date (2006-01-02 or RFC3339)")
+	end := fs.String("end", "",
+		"Skip observations at or after this date (2006-01-02 or RFC3339)")
+	user := fs.String("user", "", "Only replay observations for this username")
+	only := fs.String("sink", "",
+		"Only send to configured sinks of this Type (e.g. graphite)")
+	rate := fs.Int("rate", 1000, "Observations per second, 0 for no limit")
+	fs.Usage = func() {
+		log.Printf("usage: %s [flags] replay [replay flags] [file]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fname := Cfg.LocalDataLog
+	if fs.NArg() > 0 {
+		fname = fs.Arg(0)
+	}
+	// the data log is in unix time, so its days are UTC days
+	t0 := parseDateFlag("start", *start, time.UTC)
+	t1 := parseDateFlag("end", *end, time.UTC)
+	if *rate < 0 {
+		log.Fatalf("-rate %d is negative; use 0 for no limit", *rate)
+	}
+
+	// pick the sinks.  we do not want to append the file to itself.
+	sinks := make([]oura.SinkConfig, 0)
+	for _, sc := range Cfg.SinkConfigs() {
+		if len(*only) > 0 && sc.Type != *only {
+			continue
+		}
+		if sc.Type == "file" && sameFile(sc.Path, fname) {
+			log.Printf("not replaying %s into itself", fname)
+			continue
+		}
+		sinks = append(sinks, sc)
+	}
+	if len(sinks) == 0 {
+		log.Fatalf("no sinks to replay into")
+	}
+	Cfg.Sinks = sinks
+
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("can't open %s: %s", fname, err)
+	}
+	defer f.Close()
+
+	observationChan := make(chan oura.Observation, 100)
+	done := make(chan bool)
+	go func() {
+		oura.StoreObservations(Cfg, observationChan)
+		done <- true
+	}()
+
+	var tick <-chan time.Time
+	if *rate > 0 {
+		// past a billion a second, the interval would round down to
+		// zero, which NewTicker doesn't allow
+		interval := time.Second / time.Duration(*rate)
+		if interval < time.Nanosecond {
+			interval = time.Nanosecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sent, skipped, bad := 0, 0, 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		obs, err := oura.ParseGraphiteLine(*prefix, scanner.Text())
+		if err != nil {
+			if bad += 1; bad <= 10 {
+				log.Printf("skipping unparseable line %q: %s", scanner.Text(), err)
+			}
+			continue
+		}
+		if (len(*user) > 0 && obs.Username != *user) ||
+			(!t0.IsZero() && obs.Timestamp.Before(t0)) ||
+			(!t1.IsZero() && !obs.Timestamp.Before(t1)) {
+			skipped += 1
+			continue
+		}
+		if tick != nil {
+			<-tick
+		}
+		observationChan <- obs
+		if sent += 1; sent%100000 == 0 {
+			log.Printf("replayed %d observations so far", sent)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("error reading %s: %s", fname, err)
+	}
+	close(observationChan)
+	<-done
+	log.Printf("replayed %d observations from %s (%d filtered, %d unparseable)",
+		sent, fname, skipped, bad)
+}